@@ -0,0 +1,198 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+)
+
+const (
+	metadataFile = "metadata.json"
+	specsFile    = "specs.json"
+	packetDir    = "packets"
+	// formatVersion is increased on incompatible changes of the archive layout
+	formatVersion = 1
+)
+
+// Store is a jamesd repository, it is implemented by *db.DB
+type Store interface {
+	GetPacketNames() ([]string, error)
+	GetInfos(name string) ([]*packet.ControlInfo, error)
+	GetPacket(hash string) (*packet.Packet, error)
+	SavePacket(pack *packet.Packet) error
+	GetSpecs() ([]*spec.Spec, error)
+	SaveSpec(s *spec.Spec) error
+}
+
+// Metadata describes the content of a backup
+type Metadata struct {
+	Version int
+	Created time.Time
+	Packets []*PacketInfo
+}
+
+// PacketInfo identifies a packet of a backup
+type PacketInfo struct {
+	Name   string
+	Labels map[string]string
+	Hash   string
+}
+
+// Report summarizes an export or import
+type Report struct {
+	Packets int
+	Specs   int
+}
+
+// Export writes all packets and specs of a store as tar archive to w.
+// The archive contains metadata.json, specs.json and the packets as packets/<hash>.jpk.
+func Export(store Store, w io.Writer) (*Report, error) {
+	archive := tar.NewWriter(w)
+	now := time.Now()
+	names, err := store.GetPacketNames()
+	if err != nil {
+		return nil, err
+	}
+	metadata := &Metadata{Version: formatVersion, Created: now}
+	for _, name := range names {
+		infos, err := store.GetInfos(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			metadata.Packets = append(metadata.Packets, &PacketInfo{Name: info.Name, Labels: info.Labels, Hash: info.Hash})
+		}
+	}
+	if err = addJSON(archive, metadataFile, metadata, now); err != nil {
+		return nil, err
+	}
+	specs, err := store.GetSpecs()
+	if err != nil {
+		return nil, err
+	}
+	if err = addJSON(archive, specsFile, specs, now); err != nil {
+		return nil, err
+	}
+	for _, info := range metadata.Packets {
+		pack, err := store.GetPacket(info.Hash)
+		if err != nil {
+			return nil, err
+		}
+		data, err := pack.ToData()
+		if err != nil {
+			return nil, err
+		}
+		if err = addFile(archive, path.Join(packetDir, info.Hash+".jpk"), data, now); err != nil {
+			return nil, err
+		}
+	}
+	if err = archive.Close(); err != nil {
+		return nil, err
+	}
+	return &Report{Packets: len(metadata.Packets), Specs: len(specs)}, nil
+}
+
+// Import restores a backup created by Export into a store.
+// Every packet is verified against its hash before it is saved, the specs are saved last.
+func Import(store Store, r io.Reader) (*Report, error) {
+	archive := tar.NewReader(r)
+	var (
+		metadata *Metadata
+		specs    []*spec.Spec
+		imported = make(map[string]bool)
+	)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case hdr.Name == metadataFile:
+			metadata = &Metadata{}
+			if err = json.NewDecoder(archive).Decode(metadata); err != nil {
+				return nil, err
+			}
+			if metadata.Version > formatVersion {
+				return nil, fmt.Errorf("unsupported backup version %v", metadata.Version)
+			}
+		case hdr.Name == specsFile:
+			if err = json.NewDecoder(archive).Decode(&specs); err != nil {
+				return nil, err
+			}
+		case path.Dir(hdr.Name) == packetDir && strings.HasSuffix(hdr.Name, ".jpk"):
+			hash := strings.TrimSuffix(path.Base(hdr.Name), ".jpk")
+			if err = importPacket(store, archive, hash); err != nil {
+				return nil, err
+			}
+			imported[hash] = true
+		}
+	}
+	if metadata == nil {
+		return nil, fmt.Errorf("invalid backup: %v is missing", metadataFile)
+	}
+	for _, info := range metadata.Packets {
+		if !imported[info.Hash] {
+			return nil, fmt.Errorf("invalid backup: packet %v (%v) is missing", info.Name, info.Hash)
+		}
+	}
+	for _, s := range specs {
+		if err := store.SaveSpec(s); err != nil {
+			return nil, err
+		}
+	}
+	return &Report{Packets: len(imported), Specs: len(specs)}, nil
+}
+
+func importPacket(store Store, r io.Reader, hash string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	actual, err := packet.HashData(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if actual != hash {
+		return fmt.Errorf("packet hash mismatch: expected %v, got %v", hash, actual)
+	}
+	pack, err := packet.NewFromData(data)
+	if err != nil {
+		return err
+	}
+	if actual, err = pack.Hash(); err != nil {
+		return err
+	}
+	if actual != hash {
+		return fmt.Errorf("packet %v does not serialize reproducibly", hash)
+	}
+	return store.SavePacket(pack)
+}
+
+func addJSON(archive *tar.Writer, name string, data interface{}, modTime time.Time) error {
+	bs, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return addFile(archive, name, bs, modTime)
+}
+
+func addFile(archive *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime}
+	if err := archive.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := archive.Write(data)
+	return err
+}
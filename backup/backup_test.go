@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/internal/storetest"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+)
+
+func TestExportImport(t *testing.T) {
+	src := storetest.New()
+	for _, version := range []string{"1.0", "2.0"} {
+		pack := storetest.NewPacket(t, packet.ControlInfo{Name: "test-packet", Labels: map[string]string{"version": version}}, nil)
+		assert.NoError(t, src.SavePacket(pack))
+	}
+	assert.NoError(t, src.SaveSpec(&spec.Spec{ID: "default", Target: map[string]string{"fleet": "alpha"}}))
+
+	buf := &bytes.Buffer{}
+	report, err := Export(src, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, &Report{Packets: 2, Specs: 1}, report)
+
+	dst := storetest.New()
+	report, err = Import(dst, bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, &Report{Packets: 2, Specs: 1}, report)
+	assert.Equal(t, src.DataCount(), dst.DataCount())
+	for _, info := range src.Infos() {
+		srcPack, err := src.GetPacket(info.Hash)
+		assert.NoError(t, err)
+		dstPack, err := dst.GetPacket(info.Hash)
+		assert.NoError(t, err)
+		srcData, _ := srcPack.ToData()
+		dstData, _ := dstPack.ToData()
+		assert.Equal(t, srcData, dstData)
+	}
+	srcSpecs, _ := src.GetSpecs()
+	dstSpecs, _ := dst.GetSpecs()
+	assert.Equal(t, srcSpecs, dstSpecs)
+}
+
+func TestImportRejectsCorruptPackets(t *testing.T) {
+	buf := &bytes.Buffer{}
+	archive := tar.NewWriter(buf)
+	data := []byte("not the packet you are looking for")
+	assert.NoError(t, addJSON(archive, metadataFile, &Metadata{Version: formatVersion}, time.Time{}))
+	assert.NoError(t, addFile(archive, "packets/00000000000000000000000000000000.jpk", data, time.Time{}))
+	assert.NoError(t, archive.Close())
+
+	dst := storetest.New()
+	_, err := Import(dst, buf)
+	assert.Error(t, err)
+	assert.Equal(t, 0, dst.DataCount())
+}
@@ -0,0 +1,142 @@
+package bundle
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trusch/jamesd/cache"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/state"
+)
+
+// InfoFile is the name of the bundle info inside of the archive
+const InfoFile = "bundle.json"
+
+// packetDir is the directory of the packets inside of the archive
+const packetDir = "packets"
+
+// Info describes for which labels and when a bundle was created and contains the desired state
+type Info struct {
+	Labels  map[string]string
+	Created time.Time
+	State   *state.State
+}
+
+// Create writes a bundle as tar archive to w.
+// files maps the hashes of the packets of the state to the files containing them.
+func Create(w io.Writer, info *Info, files map[string]string) error {
+	archive := tar.NewWriter(w)
+	bs, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: InfoFile, Mode: 0644, Size: int64(len(bs)), ModTime: info.Created}
+	if err = archive.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err = archive.Write(bs); err != nil {
+		return err
+	}
+	for _, app := range info.State.Apps {
+		file, ok := files[app.Hash]
+		if !ok {
+			return fmt.Errorf("missing packet %v (%v)", app.Name, app.Hash)
+		}
+		if err = addFile(archive, path.Join(packetDir, app.Hash+cache.Suffix), file, info.Created); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func addFile(archive *tar.Writer, name, file string, modTime time.Time) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: stat.Size(), ModTime: modTime}
+	if err = archive.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(archive, f)
+	return err
+}
+
+// Extract reads a bundle and writes its packets as <hash>.jpk files to dir.
+// Every packet is verified against its hash and all packets of the state need to be present.
+func Extract(r io.Reader, dir string) (*Info, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	archive := tar.NewReader(r)
+	var info *Info
+	extracted := make(map[string]bool)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case hdr.Name == InfoFile:
+			info = &Info{}
+			if err = json.NewDecoder(archive).Decode(info); err != nil {
+				return nil, err
+			}
+		case path.Dir(hdr.Name) == packetDir && strings.HasSuffix(hdr.Name, cache.Suffix):
+			hash := strings.TrimSuffix(path.Base(hdr.Name), cache.Suffix)
+			if err = extractPacket(archive, hash, dir); err != nil {
+				return nil, err
+			}
+			extracted[hash] = true
+		}
+	}
+	if info == nil || info.State == nil {
+		return nil, errors.New("invalid bundle: " + InfoFile + " is missing")
+	}
+	for _, app := range info.State.Apps {
+		if !extracted[app.Hash] {
+			return nil, fmt.Errorf("invalid bundle: packet %v (%v) is missing", app.Name, app.Hash)
+		}
+	}
+	return info, nil
+}
+
+func extractPacket(r io.Reader, hash, dir string) error {
+	if err := packet.ValidateHash(hash); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, hash+cache.Suffix)
+	tmp := file + cache.PartialSuffix
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	actual, err := packet.HashData(io.TeeReader(r, f))
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil && actual != hash {
+		err = fmt.Errorf("packet hash mismatch: expected %v, got %v", hash, actual)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
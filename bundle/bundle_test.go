@@ -0,0 +1,61 @@
+package bundle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/internal/storetest"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+	"github.com/trusch/jamesd/state"
+)
+
+func TestBundle(t *testing.T) {
+	defer os.RemoveAll("./test-bundle")
+	assert.NoError(t, os.MkdirAll("./test-bundle/src", 0755))
+	pack := storetest.NewPacket(t, packet.ControlInfo{Name: "test-packet", Labels: map[string]string{"version": "1.0"}}, nil)
+	data, err := pack.ToData()
+	assert.NoError(t, err)
+	hash, err := packet.HashData(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile("./test-bundle/src/packet.jpk", data, 0644))
+
+	info := &Info{
+		Labels:  map[string]string{"arch": "amd64"},
+		Created: time.Now(),
+		State: &state.State{
+			Apps: []*state.App{
+				{App: &spec.App{Name: "test-packet", Labels: map[string]string{"version": "1.0"}}, Hash: hash},
+			},
+			// unresolved apps have no packet
+			Unresolved: []*state.App{
+				{App: &spec.App{Name: "missing"}, Status: state.StatusNoMatch, Reason: "no packet found"},
+			},
+		},
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, Create(buf, info, map[string]string{hash: "./test-bundle/src/packet.jpk"}))
+
+	extracted, err := Extract(bytes.NewReader(buf.Bytes()), "./test-bundle/dst")
+	assert.NoError(t, err)
+	assert.Equal(t, info.Labels, extracted.Labels)
+	assert.Equal(t, hash, extracted.State.Apps[0].Hash)
+	assert.Equal(t, state.StatusNoMatch, extracted.State.Unresolved[0].Status)
+	content, err := ioutil.ReadFile(filepath.Join("./test-bundle/dst", hash+".jpk"))
+	assert.NoError(t, err)
+	assert.Equal(t, data, content)
+
+	// packets not matching their hash are rejected
+	info.State.Apps[0].Hash = "00000000000000000000000000000000"
+	buf.Reset()
+	assert.NoError(t, Create(buf, info, map[string]string{info.State.Apps[0].Hash: "./test-bundle/src/packet.jpk"}))
+	_, err = Extract(bytes.NewReader(buf.Bytes()), "./test-bundle/dst2")
+	assert.Error(t, err)
+
+	assert.Error(t, Create(buf, info, nil))
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trusch/jamesd/packet"
+)
+
+const (
+	// Suffix is the file extension of cached packets
+	Suffix = ".jpk"
+	// PartialSuffix is appended to packets which are not completely downloaded yet
+	PartialSuffix = ".part"
+)
+
+// DownloadFunc downloads a packet to a file.
+// If the file already exists, it contains the beginning of the packet and the download should be resumed.
+type DownloadFunc func(hash, file string) error
+
+// Cache is a size bounded directory of packets named by their hash.
+// It can be pre-seeded by placing <hash>.jpk files into the directory.
+type Cache struct {
+	dir     string
+	maxSize int64
+}
+
+// New returns a cache in dir which is pruned to maxSize bytes, zero means unbounded
+func New(dir string, maxSize int64) *Cache {
+	return &Cache{dir: dir, maxSize: maxSize}
+}
+
+// Path returns the location of a cached packet
+func (c *Cache) Path(hash string) string {
+	return filepath.Join(c.dir, hash+Suffix)
+}
+
+// Fetch returns the path of a verified packet, downloading it if it is not cached yet.
+// Interrupted downloads are kept and resumed by the next call.
+func (c *Cache) Fetch(hash string, download DownloadFunc) (string, error) {
+	if err := packet.ValidateHash(hash); err != nil {
+		return "", err
+	}
+	path := c.Path(hash)
+	if _, err := os.Stat(path); err == nil {
+		if err = Verify(path, hash); err == nil {
+			now := time.Now()
+			return path, os.Chtimes(path, now, now)
+		}
+		if err = os.Remove(path); err != nil {
+			return "", err
+		}
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return "", err
+	}
+	partial := path + PartialSuffix
+	if err := download(hash, partial); err != nil {
+		return "", err
+	}
+	if err := Verify(partial, hash); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return "", err
+	}
+	return path, c.Prune(hash)
+}
+
+// Prune removes the least recently used packets until the cache fits into its size.
+// The packets with the given hashes are kept.
+func (c *Cache) Prune(keep ...string) error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	kept := make(map[string]bool)
+	for _, hash := range keep {
+		kept[hash+Suffix] = true
+	}
+	size := int64(0)
+	candidates := make([]os.FileInfo, 0, len(files))
+	for _, info := range files {
+		if info.IsDir() {
+			continue
+		}
+		size += info.Size()
+		if strings.HasSuffix(info.Name(), Suffix) && !kept[info.Name()] {
+			candidates = append(candidates, info)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ModTime().Before(candidates[j].ModTime()) })
+	for _, info := range candidates {
+		if size <= c.maxSize {
+			break
+		}
+		if err = os.Remove(filepath.Join(c.dir, info.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		size -= info.Size()
+	}
+	return nil
+}
+
+// Verify checks that a file contains the packet with the given hash
+func Verify(file, hash string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	actual, err := packet.HashData(f)
+	if err != nil {
+		return err
+	}
+	if actual != hash {
+		return fmt.Errorf("packet hash mismatch: expected %v, got %v", hash, actual)
+	}
+	return nil
+}
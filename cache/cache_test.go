@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/internal/storetest"
+	"github.com/trusch/jamesd/packet"
+)
+
+func createTestPacketData(t *testing.T, name string) (string, []byte) {
+	pack := storetest.NewPacket(t, packet.ControlInfo{Name: name, Labels: map[string]string{"a": "label"}}, nil)
+	data, err := pack.ToData()
+	assert.NoError(t, err)
+	hash, err := packet.HashData(bytes.NewReader(data))
+	assert.NoError(t, err)
+	return hash, data
+}
+
+func TestFetch(t *testing.T) {
+	defer os.RemoveAll("./test-cache")
+	hash, data := createTestPacketData(t, "test-packet")
+	c := New("./test-cache", 0)
+
+	// the first attempt gets interrupted, the second one resumes
+	interrupted := errors.New("interrupted")
+	_, err := c.Fetch(hash, func(hash, file string) error {
+		assert.NoError(t, ioutil.WriteFile(file, data[:10], 0644))
+		return interrupted
+	})
+	assert.Equal(t, interrupted, err)
+	path, err := c.Fetch(hash, func(hash, file string) error {
+		content, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, data[:10], content)
+		return ioutil.WriteFile(file, data, 0644)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, c.Path(hash), path)
+
+	// cached packets are not downloaded again
+	_, err = c.Fetch(hash, func(hash, file string) error {
+		return errors.New("should not download")
+	})
+	assert.NoError(t, err)
+
+	// corrupt downloads are rejected
+	otherHash, _ := createTestPacketData(t, "other-packet")
+	_, err = c.Fetch(otherHash, func(hash, file string) error {
+		return ioutil.WriteFile(file, data, 0644)
+	})
+	assert.Error(t, err)
+	_, err = os.Stat(c.Path(otherHash) + PartialSuffix)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = c.Fetch("../../etc/passwd", nil)
+	assert.Error(t, err)
+}
+
+func TestPrune(t *testing.T) {
+	defer os.RemoveAll("./test-cache")
+	hashA, dataA := createTestPacketData(t, "a")
+	hashB, dataB := createTestPacketData(t, "b")
+	c := New("./test-cache", int64(len(dataA)+len(dataB)-1))
+	_, err := c.Fetch(hashA, func(hash, file string) error { return ioutil.WriteFile(file, dataA, 0644) })
+	assert.NoError(t, err)
+	_, err = c.Fetch(hashB, func(hash, file string) error { return ioutil.WriteFile(file, dataB, 0644) })
+	assert.NoError(t, err)
+
+	_, err = os.Stat(c.Path(hashA))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(c.Path(hashB))
+	assert.NoError(t, err)
+}
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/trusch/jamesd/packet"
 	"github.com/trusch/jamesd/spec"
@@ -16,14 +19,17 @@ import (
 
 // Client is a jamesd client class
 type Client struct {
-	endpoint string
-	client   *http.Client
-	token    string
+	endpoints []string
+	current   int
+	mutex     sync.Mutex
+	client    *http.Client
+	token     string
 }
 
-// New returns a new client
-func New(endpoint string) *Client {
-	return &Client{endpoint, &http.Client{}, ""}
+// New returns a new client.
+// If a request fails because the server is unreachable, it is retried with the fallback endpoints.
+func New(endpoint string, fallbacks ...string) *Client {
+	return &Client{endpoints: append([]string{endpoint}, fallbacks...), client: &http.Client{}}
 }
 
 // SetToken sets the auth token
@@ -31,14 +37,47 @@ func (cli *Client) SetToken(token string) {
 	cli.token = token
 }
 
+// do sends a request to the endpoint which answered last.
+// On connection errors the other endpoints are tried in order.
+func (cli *Client) do(method, path string, body []byte) (*http.Response, error) {
+	return cli.doWithHeader(method, path, body, nil)
+}
+
+// doWithHeader is like do but adds the given header fields to the request
+func (cli *Client) doWithHeader(method, path string, body []byte, header http.Header) (*http.Response, error) {
+	cli.mutex.Lock()
+	start := cli.current
+	cli.mutex.Unlock()
+	var err error
+	for i := 0; i < len(cli.endpoints); i++ {
+		idx := (start + i) % len(cli.endpoints)
+		var req *http.Request
+		req, err = http.NewRequest(method, cli.endpoints[idx]+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.Header.Set("Authorization", "Bearer "+cli.token)
+		var resp *http.Response
+		resp, err = cli.client.Do(req)
+		if err == nil {
+			cli.mutex.Lock()
+			cli.current = idx
+			cli.mutex.Unlock()
+			return resp, nil
+		}
+		if _, ok := err.(*url.Error); !ok {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
 // GetPackets returns a list of all packet control infos
 func (cli *Client) GetPackets() (map[string][]*packet.ControlInfo, error) {
-	req, err := http.NewRequest("GET", cli.endpoint+"/packet/", nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	resp, err := cli.do("GET", "/packet/", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -61,12 +100,7 @@ func (cli *Client) UploadPacket(pack *packet.Packet) error {
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", cli.endpoint+"/packet/", bytes.NewReader(data))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	resp, err := cli.do("POST", "/packet/", data)
 	if err != nil {
 		return err
 	}
@@ -79,18 +113,26 @@ func (cli *Client) UploadPacket(pack *packet.Packet) error {
 
 // GetDesiredState returns a list of packets to be installed for a given labelset
 func (cli *Client) GetDesiredState(labels map[string]string) (*state.State, error) {
+	return cli.GetDesiredStateIfChanged(labels, "")
+}
+
+// GetDesiredStateIfChanged is like GetDesiredState, but returns nil if the state still has the given revision
+func (cli *Client) GetDesiredStateIfChanged(labels map[string]string, revision string) (*state.State, error) {
 	buf := &bytes.Buffer{}
 	encoder := json.NewEncoder(buf)
 	encoder.Encode(labels)
-	req, err := http.NewRequest("POST", cli.endpoint+"/packet/compute", buf)
-	if err != nil {
-		return nil, err
+	header := http.Header{}
+	if revision != "" {
+		header.Set("If-None-Match", `"`+revision+`"`)
 	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	resp, err := cli.doWithHeader("POST", "/packet/compute", buf.Bytes(), header)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
 	if resp.StatusCode != http.StatusOK {
 		msg, _ := ioutil.ReadAll(resp.Body)
 		return nil, errors.New("http error: " + strconv.Itoa(resp.StatusCode) + " " + string(msg))
@@ -104,15 +146,48 @@ func (cli *Client) GetDesiredState(labels map[string]string) (*state.State, erro
 	return result, nil
 }
 
-// DeletePacket deletes a packet from the server
-func (cli *Client) DeletePacket(hash string) error {
-	url := fmt.Sprintf("%v/packet/%v", cli.endpoint, hash)
-	req, err := http.NewRequest("DELETE", url, nil)
+// ErrWatchUnsupported is returned by WatchDesiredState if the server does not support watching
+var ErrWatchUnsupported = errors.New("server does not support watching the desired state")
+
+// WatchDesiredState waits until the desired state for a given labelset differs from the given revision.
+// If the state did not change until the timeout, nil is returned.
+func (cli *Client) WatchDesiredState(labels map[string]string, revision string, timeout time.Duration) (*state.State, error) {
+	bs, err := json.Marshal(labels)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("labels", string(bs))
+	query.Set("revision", revision)
+	query.Set("timeout", timeout.String())
+	resp, err := cli.do("GET", "/packet/watch?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, ErrWatchUnsupported
+	default:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, errors.New("http error: " + strconv.Itoa(resp.StatusCode) + " " + string(msg))
+	}
+	result := &state.State{}
+	decoder := json.NewDecoder(resp.Body)
+	err = decoder.Decode(result)
+	if err != nil {
+		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	return result, nil
+}
+
+// DeletePacket deletes a packet from the server.
+// Packets which are the best match of a spec are only deleted if force is set.
+func (cli *Client) DeletePacket(hash string, force bool) error {
+	resp, err := cli.do("DELETE", fmt.Sprintf("/packet/%v", hash)+forceQuery(force), nil)
 	if err != nil {
 		return err
 	}
@@ -125,13 +200,7 @@ func (cli *Client) DeletePacket(hash string) error {
 
 // GetPacketInfo returns the packet info to a given hash
 func (cli *Client) GetPacketInfo(hash string) (*packet.ControlInfo, error) {
-	url := fmt.Sprintf("%v/packet/%v/info", cli.endpoint, hash)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	resp, err := cli.do("GET", fmt.Sprintf("/packet/%v/info", hash), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -150,13 +219,7 @@ func (cli *Client) GetPacketInfo(hash string) (*packet.ControlInfo, error) {
 
 // GetPacketData returns the packet info to a given hash
 func (cli *Client) GetPacketData(hash string) (*packet.Packet, error) {
-	url := fmt.Sprintf("%v/packet/%v/data", cli.endpoint, hash)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	resp, err := cli.do("GET", fmt.Sprintf("/packet/%v/data", hash), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -177,12 +240,7 @@ func (cli *Client) GetPacketData(hash string) (*packet.Packet, error) {
 
 // GetSpecs returns a list of all packet control infos
 func (cli *Client) GetSpecs() ([]*spec.Spec, error) {
-	req, err := http.NewRequest("GET", cli.endpoint+"/spec/", nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	resp, err := cli.do("GET", "/spec/", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -199,20 +257,16 @@ func (cli *Client) GetSpecs() ([]*spec.Spec, error) {
 	return result, nil
 }
 
-// UploadSpec sends a packet to the server
-func (cli *Client) UploadSpec(s *spec.Spec) error {
+// UploadSpec sends a packet to the server.
+// Specs with apps which don't resolve for their target are only accepted if force is set.
+func (cli *Client) UploadSpec(s *spec.Spec, force bool) error {
 	buf := &bytes.Buffer{}
 	encoder := json.NewEncoder(buf)
 	err := encoder.Encode(s)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", cli.endpoint+"/spec/", buf)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	resp, err := cli.do("POST", "/spec/"+forceQuery(force), buf.Bytes())
 	if err != nil {
 		return err
 	}
@@ -231,12 +285,7 @@ func (cli *Client) GetMergedSpec(labels map[string]string) (*spec.Spec, error) {
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", cli.endpoint+"/spec/compute", buf)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	resp, err := cli.do("POST", "/spec/compute", buf.Bytes())
 	if err != nil {
 		return nil, err
 	}
@@ -255,12 +304,7 @@ func (cli *Client) GetMergedSpec(labels map[string]string) (*spec.Spec, error) {
 
 // GetSpec returns a specific spec
 func (cli *Client) GetSpec(id string) (*spec.Spec, error) {
-	req, err := http.NewRequest("GET", cli.endpoint+"/spec/"+id, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	resp, err := cli.do("GET", "/spec/"+id, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -277,20 +321,16 @@ func (cli *Client) GetSpec(id string) (*spec.Spec, error) {
 	return result, nil
 }
 
-// PutSpec sends a packet to the server
-func (cli *Client) PutSpec(s *spec.Spec) error {
+// PutSpec sends a packet to the server.
+// Specs with apps which don't resolve for their target are only accepted if force is set.
+func (cli *Client) PutSpec(s *spec.Spec, force bool) error {
 	buf := &bytes.Buffer{}
 	encoder := json.NewEncoder(buf)
 	err := encoder.Encode(s)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("PUT", cli.endpoint+"/spec/"+s.ID, buf)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	resp, err := cli.do("PUT", "/spec/"+s.ID+forceQuery(force), buf.Bytes())
 	if err != nil {
 		return err
 	}
@@ -303,12 +343,7 @@ func (cli *Client) PutSpec(s *spec.Spec) error {
 
 // DeleteSpec returns a specific spec
 func (cli *Client) DeleteSpec(id string) error {
-	req, err := http.NewRequest("DELETE", cli.endpoint+"/spec/"+id, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+cli.token)
-	resp, err := cli.client.Do(req)
+	resp, err := cli.do("DELETE", "/spec/"+id, nil)
 	if err != nil {
 		return err
 	}
@@ -318,3 +353,10 @@ func (cli *Client) DeleteSpec(id string) error {
 	}
 	return nil
 }
+
+func forceQuery(force bool) string {
+	if force {
+		return "?force=true"
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// downloadAttempts is the number of times an interrupted download is resumed before giving up
+const downloadAttempts = 3
+
+// DownloadPacket downloads the serialized data of a packet to a file.
+// If the file already contains the beginning of the packet, only the rest is requested.
+// Interrupted downloads are resumed a few times, the content is not verified.
+func (cli *Client) DownloadPacket(hash, file string) error {
+	var err error
+	for attempt := 0; attempt < downloadAttempts; attempt++ {
+		var done bool
+		done, err = cli.downloadPacket(hash, file)
+		if done {
+			return err
+		}
+		log.Printf("download of %v interrupted: %v", hash, err)
+	}
+	return err
+}
+
+// downloadPacket does a single download attempt.
+// It reports whether retrying makes no sense because the download either succeeded or failed permanently.
+func (cli *Client) downloadPacket(hash, file string) (bool, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return true, err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return true, err
+	}
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
+	}
+	resp, err := cli.doWithHeader("GET", fmt.Sprintf("/packet/%v/data", hash), nil, header)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server ignored the range, start from scratch
+		if err = f.Truncate(0); err != nil {
+			return true, err
+		}
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return true, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the file is complete already or garbage, the verification will tell
+		return true, nil
+	default:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return true, errors.New("http error: " + strconv.Itoa(resp.StatusCode) + " " + string(msg))
+	}
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		return false, err
+	}
+	return true, nil
+}
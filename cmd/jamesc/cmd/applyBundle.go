@@ -0,0 +1,80 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/trusch/jamesd/bundle"
+	"github.com/trusch/jamesd/cache"
+)
+
+// applyBundleCmd represents the apply-bundle command
+var applyBundleCmd = &cobra.Command{
+	Use:   "apply-bundle <file>",
+	Short: "install the desired state of an offline bundle",
+	Long: `This installs the desired state contained in a bundle created by 'jamesd-ctl bundle create'
+exactly like a state received from jamesd: obsolete packets are uninstalled, others upgraded or installed.
+It exits like 'jamesc sync --once', except that the server can't be unreachable.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		code, err := applyBundle(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(code)
+	},
+}
+
+// applyBundle extracts a bundle to a temporary directory, reconciles with its state and returns the exit code.
+// The extracted packets are removed before it returns.
+func applyBundle(name string) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	tmp, err := ioutil.TempDir("", "jamesc-bundle")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
+	info, err := bundle.Extract(f, tmp)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("applying bundle for %v created at %v", formatLabels(info.Labels), info.Created)
+	src := &source{
+		cache: cache.New(tmp, 0),
+		download: func(hash, file string) error {
+			return fmt.Errorf("packet %v is not part of the bundle", hash)
+		},
+	}
+	return summarize(reconcile(src, info.State)), nil
+}
+
+func init() {
+	RootCmd.AddCommand(applyBundleCmd)
+}
@@ -0,0 +1,79 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff computes the delays between the polls of jamesd.
+// After errors the interval grows exponentially up to max, so that restarted servers
+// are not overrun by all clients at once. All delays get randomized by jitter.
+type backoff struct {
+	interval time.Duration
+	max      time.Duration
+	failures uint
+}
+
+// newBackoff returns a backoff for polls every interval.
+// A max below the interval would shorten the delays after errors, so it is raised to the interval.
+func newBackoff(interval, max time.Duration) *backoff {
+	if max < interval {
+		max = interval
+	}
+	return &backoff{interval: interval, max: max}
+}
+
+// next returns the delay until the next poll
+func (b *backoff) next(failed bool) time.Duration {
+	if !failed {
+		b.failures = 0
+		return b.interval
+	}
+	b.failures++
+	delay := b.interval
+	for i := uint(0); i < b.failures && delay < b.max; i++ {
+		// stop doubling before the delay could overflow
+		if delay >= b.max/2 {
+			delay = b.max
+			break
+		}
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	// keep at least half of the delay and randomize the rest
+	return delay/2 + randomDuration(delay/2)
+}
+
+// randomDuration returns a random duration in [0, max)
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		interval time.Duration
+		max      time.Duration
+	}{
+		{"default", 30 * time.Second, 10 * time.Minute},
+		{"max below interval", time.Minute, 30 * time.Second},
+		{"no max", time.Minute, 0},
+		{"huge max", 30 * time.Second, time.Duration(1<<63 - 1)},
+	} {
+		b := newBackoff(c.interval, c.max)
+		if c.max < c.interval {
+			// the max is raised to the interval
+			c.max = c.interval
+		}
+		assert.Equal(t, c.max, b.max, c.name)
+		expected := c.interval
+		for failures := 1; failures <= 100; failures++ {
+			if expected < c.max/2 {
+				expected *= 2
+			} else {
+				expected = c.max
+			}
+			if expected > c.max {
+				expected = c.max
+			}
+			delay := b.next(true)
+			// jitter keeps at least half of the delay
+			assert.True(t, delay >= expected/2 && delay <= expected, "%v: delay %v after %v failures, expected %v", c.name, delay, failures, expected)
+		}
+		assert.Equal(t, c.interval, b.next(false), c.name)
+		delay := b.next(true)
+		expected = 2 * c.interval
+		if expected > c.max {
+			expected = c.max
+		}
+		assert.True(t, delay >= expected/2 && delay <= expected, "%v: delay %v after reset", c.name, delay)
+	}
+}
+
+func TestRandomDuration(t *testing.T) {
+	assert.Equal(t, time.Duration(0), randomDuration(0))
+	assert.Equal(t, time.Duration(0), randomDuration(-time.Second))
+	for i := 0; i < 100; i++ {
+		d := randomDuration(time.Second)
+		assert.True(t, d >= 0 && d < time.Second)
+	}
+}
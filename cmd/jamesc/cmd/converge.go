@@ -0,0 +1,378 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/trusch/jamesd/cache"
+	"github.com/trusch/jamesd/cli"
+	"github.com/trusch/jamesd/installer"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/state"
+)
+
+// upgrade replaces an installed packet with another packet of the same name
+type upgrade struct {
+	from *packet.Packet
+	to   *state.App
+}
+
+// plan lists the steps to converge from the installed to the desired state.
+// Packets of unresolved apps are left untouched.
+type plan struct {
+	uninstall  []*packet.Packet
+	upgrade    []*upgrade
+	install    []*state.App
+	unresolved []*state.App
+}
+
+func (p *plan) empty() bool {
+	return len(p.uninstall) == 0 && len(p.upgrade) == 0 && len(p.install) == 0
+}
+
+func computePlan(installed []*packet.Packet, desired *state.State) *plan {
+	res := &plan{unresolved: desired.Unresolved}
+	desiredHashes := make(map[string]bool)
+	for _, app := range desired.Apps {
+		desiredHashes[app.Hash] = true
+	}
+	unresolvedNames := make(map[string]bool)
+	for _, app := range desired.Unresolved {
+		unresolvedNames[app.Name] = true
+	}
+	installedHashes := make(map[string]bool)
+	obsolete := make([]*packet.Packet, 0, len(installed))
+	for _, pack := range installed {
+		installedHashes[pack.ControlInfo.Hash] = true
+		if !desiredHashes[pack.ControlInfo.Hash] && !unresolvedNames[pack.Name] {
+			obsolete = append(obsolete, pack)
+		}
+	}
+	for _, app := range desired.Apps {
+		if installedHashes[app.Hash] {
+			continue
+		}
+		upgraded := false
+		for idx, pack := range obsolete {
+			if pack.Name == app.Name {
+				res.upgrade = append(res.upgrade, &upgrade{from: pack, to: app})
+				obsolete = append(obsolete[:idx], obsolete[idx+1:]...)
+				upgraded = true
+				break
+			}
+		}
+		if !upgraded {
+			res.install = append(res.install, app)
+		}
+	}
+	res.uninstall = obsolete
+	return res
+}
+
+// report summarizes the steps taken by converge and the apps jamesd could not resolve
+type report struct {
+	uninstalled []string
+	upgraded    []string
+	installed   []string
+	failed      []string
+	unresolved  []string
+}
+
+func (r *report) empty() bool {
+	return len(r.uninstalled) == 0 && len(r.upgraded) == 0 && len(r.installed) == 0 && len(r.failed) == 0
+}
+
+func (r *report) String() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%v uninstalled, %v upgraded, %v installed, %v failed, %v unresolved\n",
+		len(r.uninstalled), len(r.upgraded), len(r.installed), len(r.failed), len(r.unresolved))
+	for _, step := range []struct {
+		name  string
+		items []string
+	}{{"uninstalled", r.uninstalled}, {"upgraded", r.upgraded}, {"installed", r.installed}, {"failed", r.failed}, {"unresolved", r.unresolved}} {
+		for _, item := range step.items {
+			fmt.Fprintf(buf, "  %v: %v\n", step.name, item)
+		}
+	}
+	return buf.String()
+}
+
+func converge(src *source, packetRoot, installRoot string, desired *state.State) (*report, error) {
+	installed, err := loadInstalledPackets(packetRoot, installRoot)
+	if err != nil {
+		return nil, err
+	}
+	p := computePlan(installed, desired)
+	res := &report{}
+	for _, app := range p.unresolved {
+		item := fmt.Sprintf("%v (%v: %v)", app.Name, app.Status, app.Reason)
+		log.Printf("UNRESOLVED %v, leaving it untouched", item)
+		res.unresolved = append(res.unresolved, item)
+	}
+	for _, pack := range p.uninstall {
+		item := fmt.Sprintf("%v (%v)", pack.Name, pack.ControlInfo.Hash)
+		if err := uninstall(packetRoot, installRoot, pack); err != nil {
+			log.Printf("ERROR in UNINSTALL of %v: %v", item, err)
+			res.failed = append(res.failed, item)
+			continue
+		}
+		res.uninstalled = append(res.uninstalled, item)
+	}
+	for _, u := range p.upgrade {
+		item := fmt.Sprintf("%v (%v -> %v)", u.to.Name, u.from.ControlInfo.Hash, u.to.Hash)
+		if err := upgradePacket(src, packetRoot, installRoot, u); err != nil {
+			log.Printf("ERROR in UPGRADE of %v: %v", item, err)
+			res.failed = append(res.failed, item)
+			continue
+		}
+		res.upgraded = append(res.upgraded, item)
+	}
+	for _, app := range p.install {
+		item := fmt.Sprintf("%v (%v)", app.Name, app.Hash)
+		if err := install(src, packetRoot, installRoot, app); err != nil {
+			log.Printf("ERROR in INSTALL of %v: %v", item, err)
+			res.failed = append(res.failed, item)
+			continue
+		}
+		res.installed = append(res.installed, item)
+	}
+	if len(res.failed) > 0 {
+		return res, fmt.Errorf("%v of %v steps failed", len(res.failed), len(p.uninstall)+len(p.upgrade)+len(p.install))
+	}
+	return res, nil
+}
+
+// loadInstalledPackets returns the packets recorded as installed in the database of the install root.
+// Installations of jamesc without database get migrated first.
+func loadInstalledPackets(packetRoot, installRoot string) ([]*packet.Packet, error) {
+	if !hasDatabase(installRoot) {
+		if err := migrate(packetRoot, installRoot); err != nil {
+			return nil, err
+		}
+	}
+	db, err := installer.OpenDatabase(installRoot)
+	if err != nil {
+		return nil, err
+	}
+	records := db.Installed()
+	res := make([]*packet.Packet, 0, len(records))
+	for _, record := range records {
+		pack, err := packet.NewFromFile(filepath.Join(packetRoot, record.Hash+".jpk"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load installed packet %v (%v): %v", record.Name, record.Hash, err)
+		}
+		res = append(res, pack)
+	}
+	return res, nil
+}
+
+func hasDatabase(installRoot string) bool {
+	_, err := os.Stat(filepath.Join(installRoot, installer.DatabaseFile))
+	return !os.IsNotExist(err)
+}
+
+// migrate registers the packets stored by jamesc versions without database as installed
+func migrate(packetRoot, installRoot string) error {
+	packs, err := legacyPackets(packetRoot)
+	if err != nil {
+		return err
+	}
+	for _, pack := range packs {
+		info, err := os.Stat(filepath.Join(packetRoot, pack.ControlInfo.Hash+".jpk"))
+		if err != nil {
+			return err
+		}
+		if err = installer.Register(pack, installRoot, info.ModTime()); err != nil {
+			return err
+		}
+		log.Printf("migrated %v (%v)", pack.Name, pack.ControlInfo.Hash)
+	}
+	return nil
+}
+
+// legacyPackets returns the packets stored by jamesc versions without database.
+// Files which are no valid packets or not named by their hash are ignored.
+func legacyPackets(packetRoot string) ([]*packet.Packet, error) {
+	res := make([]*packet.Packet, 0, 32)
+	files, err := ioutil.ReadDir(packetRoot)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range files {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".jpk") {
+			continue
+		}
+		pack, err := packet.NewFromFile(filepath.Join(packetRoot, info.Name()))
+		if err != nil {
+			log.Printf("ignoring %v: %v", info.Name(), err)
+			continue
+		}
+		hash, err := pack.Hash()
+		if err != nil || hash+".jpk" != info.Name() {
+			log.Printf("ignoring %v: hash mismatch", info.Name())
+			continue
+		}
+		res = append(res, pack)
+	}
+	return res, nil
+}
+
+// source provides the packets to install
+type source struct {
+	cache    *cache.Cache
+	download cache.DownloadFunc
+}
+
+// newSource returns a source downloading the packets from jamesd into the download cache
+func newSource(client *cli.Client) *source {
+	return &source{
+		cache:    cache.New(viper.GetString("cache-dir"), viper.GetInt64("cache-size")<<20),
+		download: client.DownloadPacket,
+	}
+}
+
+// fetch returns a packet from the cache, downloading it if needed
+func (src *source) fetch(hash string) (*packet.Packet, string, error) {
+	path, err := src.cache.Fetch(hash, src.download)
+	if err != nil {
+		return nil, "", err
+	}
+	pack, err := packet.NewFromFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	// the file content is verified already
+	pack.ControlInfo.Hash = hash
+	return pack, path, nil
+}
+
+// storePacket keeps a copy of an installed packet, so it can be uninstalled later on
+func storePacket(packetRoot, hash, file string) error {
+	if err := os.MkdirAll(packetRoot, 0755); err != nil {
+		return err
+	}
+	dst := filepath.Join(packetRoot, hash+".jpk")
+	if err := os.Link(file, dst); err == nil || os.IsExist(err) {
+		return nil
+	}
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := dst + cache.PartialSuffix
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func uninstall(packetRoot, installRoot string, pack *packet.Packet) error {
+	remove := installer.Uninstall
+	if viper.GetBool("purge") {
+		remove = installer.Purge
+	}
+	if err := remove(pack, installRoot); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(packetRoot, pack.ControlInfo.Hash+".jpk")); err != nil {
+		return err
+	}
+	log.Printf("uninstalled %v (%v)", pack.Name, pack.ControlInfo.Hash)
+	return nil
+}
+
+func upgradePacket(src *source, packetRoot, installRoot string, u *upgrade) error {
+	pack, file, err := src.fetch(u.to.Hash)
+	if err != nil {
+		return err
+	}
+	if err = installer.Upgrade(u.from, pack, installRoot); err != nil {
+		return err
+	}
+	if err = storePacket(packetRoot, pack.ControlInfo.Hash, file); err != nil {
+		return err
+	}
+	if err = os.Remove(filepath.Join(packetRoot, u.from.ControlInfo.Hash+".jpk")); err != nil {
+		return err
+	}
+	log.Printf("upgraded %v from %v to %v", u.to.Name, u.from.Labels["version"], pack.Labels["version"])
+	return nil
+}
+
+func install(src *source, packetRoot, installRoot string, app *state.App) error {
+	pack, file, err := src.fetch(app.Hash)
+	if err != nil {
+		return err
+	}
+	if err = installer.Install(pack, installRoot); err != nil {
+		return err
+	}
+	if err = storePacket(packetRoot, pack.ControlInfo.Hash, file); err != nil {
+		return err
+	}
+	log.Printf("installed %v (%+v)", app.Name, app.Labels)
+	return nil
+}
+
+func heal(packetRoot, installRoot string) error {
+	installed, err := loadInstalledPackets(packetRoot, installRoot)
+	if err != nil {
+		return err
+	}
+	for _, pack := range installed {
+		drifts, err := installer.Verify(pack, installRoot)
+		if err != nil {
+			return err
+		}
+		if len(drifts) == 0 {
+			continue
+		}
+		for _, drift := range drifts {
+			log.Printf("%v (%v): %v %v", pack.Name, pack.ControlInfo.Hash, drift.Problem, drift.Path)
+		}
+		if err = installer.Install(pack, installRoot); err != nil {
+			return err
+		}
+		log.Printf("reinstalled %v (%v)", pack.Name, pack.ControlInfo.Hash)
+	}
+	return nil
+}
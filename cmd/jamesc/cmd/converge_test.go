@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+	"github.com/trusch/jamesd/state"
+)
+
+func installedPacket(name, hash string) *packet.Packet {
+	return &packet.Packet{ControlInfo: packet.ControlInfo{Name: name, Hash: hash}}
+}
+
+func desiredApp(name, hash string) *state.App {
+	return &state.App{App: &spec.App{Name: name}, Hash: hash, Status: state.StatusResolved}
+}
+
+func unresolvedApp(name string) *state.App {
+	return &state.App{App: &spec.App{Name: name}, Status: state.StatusNoMatch, Reason: "no packet found"}
+}
+
+// steps lists the plan as "uninstall a1", "upgrade a1 a2", "install b1" and "unresolved c"
+func steps(p *plan) []string {
+	res := make([]string, 0)
+	for _, pack := range p.uninstall {
+		res = append(res, "uninstall "+pack.ControlInfo.Hash)
+	}
+	for _, u := range p.upgrade {
+		res = append(res, "upgrade "+u.from.ControlInfo.Hash+" "+u.to.Hash)
+	}
+	for _, app := range p.install {
+		res = append(res, "install "+app.Hash)
+	}
+	for _, app := range p.unresolved {
+		res = append(res, "unresolved "+app.Name)
+	}
+	return res
+}
+
+func TestComputePlan(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		installed  []*packet.Packet
+		desired    []*state.App
+		unresolved []*state.App
+		steps      []string
+	}{
+		{
+			name:    "install",
+			desired: []*state.App{desiredApp("a", "a1")},
+			steps:   []string{"install a1"},
+		},
+		{
+			name:      "upgrade",
+			installed: []*packet.Packet{installedPacket("a", "a1")},
+			desired:   []*state.App{desiredApp("a", "a2")},
+			steps:     []string{"upgrade a1 a2"},
+		},
+		{
+			name:      "removal",
+			installed: []*packet.Packet{installedPacket("a", "a1"), installedPacket("b", "b1")},
+			desired:   []*state.App{desiredApp("a", "a1")},
+			steps:     []string{"uninstall b1"},
+		},
+		{
+			name:      "unchanged",
+			installed: []*packet.Packet{installedPacket("a", "a1")},
+			desired:   []*state.App{desiredApp("a", "a1")},
+			steps:     []string{},
+		},
+		{
+			name:      "apps of old jamesd versions have no status",
+			installed: []*packet.Packet{installedPacket("a", "a1")},
+			desired:   []*state.App{{App: &spec.App{Name: "a"}, Hash: "a2"}},
+			steps:     []string{"upgrade a1 a2"},
+		},
+		{
+			name:       "unresolved app keeps the installed packet",
+			installed:  []*packet.Packet{installedPacket("a", "a1"), installedPacket("b", "b1")},
+			desired:    []*state.App{desiredApp("b", "b2")},
+			unresolved: []*state.App{unresolvedApp("a"), unresolvedApp("c")},
+			steps:      []string{"upgrade b1 b2", "unresolved a", "unresolved c"},
+		},
+	} {
+		p := computePlan(c.installed, &state.State{Apps: c.desired, Unresolved: c.unresolved})
+		assert.Equal(t, c.steps, steps(p), c.name)
+		assert.Equal(t, len(c.steps) == len(p.unresolved), p.empty(), c.name)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+// factsCmd represents the facts command
+var factsCmd = &cobra.Command{
+	Use:   "facts",
+	Short: "print the collected facts",
+	Long: `This prints the facts collected about the system: architecture, kernel, os, libc, hostname, machine-id
+and the facts of the user defined providers in the facts directory.
+With --facts they are sent to jamesd as labels together with the configured labels.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		facts, err := collectFacts()
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys := make([]string, 0, len(facts))
+		for key := range facts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("%v=%v\n", key, facts[key])
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(factsCmd)
+}
@@ -0,0 +1,89 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/trusch/jamesd/packet"
+)
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "print the steps needed to reach the desired state",
+	Long: `This fetches the desired state for the labels of this device, compares it to the installed packets
+and prints the uninstalls, upgrades and installs in the order they would be done. The system is not touched.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		installRoot := viper.GetString("root")
+		packetDir := viper.GetString("packets")
+		state, err := newClient().GetDesiredState(getLabels(cmd))
+		if err != nil {
+			log.Fatal(err)
+		}
+		var installed []*packet.Packet
+		if hasDatabase(installRoot) {
+			installed, err = loadInstalledPackets(packetDir, installRoot)
+		} else {
+			installed, err = legacyPackets(packetDir)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		p := computePlan(installed, state)
+		for _, app := range p.unresolved {
+			fmt.Printf("unresolved %v %v: %v %v\n", app.Name, formatLabels(app.Labels), app.Status, app.Reason)
+		}
+		if p.empty() {
+			fmt.Println("nothing to do")
+			return
+		}
+		for _, pack := range p.uninstall {
+			fmt.Printf("uninstall %v %v (%v)\n", pack.Name, formatLabels(pack.Labels), pack.ControlInfo.Hash)
+		}
+		for _, u := range p.upgrade {
+			fmt.Printf("upgrade   %v %v (%v) -> %v (%v)\n", u.to.Name,
+				formatLabels(u.from.Labels), u.from.ControlInfo.Hash, formatLabels(u.to.Labels), u.to.Hash)
+		}
+		for _, app := range p.install {
+			fmt.Printf("install   %v %v (%v)\n", app.Name, formatLabels(app.Labels), app.Hash)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(planCmd)
+}
+
+// formatLabels prints labels sorted by key like "{a=b,c=d}"
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
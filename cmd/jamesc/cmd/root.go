@@ -21,20 +21,17 @@
 package cmd
 
 import (
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/trusch/jamesd/cli"
+	"github.com/trusch/jamesd/facts"
 	"github.com/trusch/jamesd/installer"
-	"github.com/trusch/jamesd/packet"
 	"github.com/trusch/jamesd/state"
 )
 
@@ -46,34 +43,97 @@ var RootCmd = &cobra.Command{
 	Short: "A client for automatic software installation via jamesd",
 	Long:  `This client polls jamesd periodically, asking for packages to be installed and installs them accordingly.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		jamesdAddr := viper.GetString("addr")
-		token := viper.GetString("token")
-		interval := viper.GetDuration("interval")
-		installRoot := viper.GetString("root")
-		packetDir := viper.GetString("packets")
-
-		labels := getLabels(cmd)
-		client := cli.New(jamesdAddr)
-		if token != "" {
-			client.SetToken(token)
+		run(cmd)
+	},
+}
+
+// run reconciles the installed packets with the desired state.
+// If enabled and supported by jamesd, changes of the desired state are watched, otherwise it is polled every interval.
+// The first check is delayed randomly and errors let the interval grow, so clients don't poll jamesd in lockstep.
+func run(cmd *cobra.Command) {
+	interval := viper.GetDuration("interval")
+	delay := newBackoff(interval, viper.GetDuration("max-backoff"))
+	watch := viper.GetBool("watch")
+	client := newClient()
+	labels := getLabels(cmd)
+	revision := ""
+	time.Sleep(randomDuration(viper.GetDuration("initial-delay")))
+	for {
+		var (
+			desired *state.State
+			err     error
+		)
+		if watch {
+			desired, err = client.WatchDesiredState(labels, revision, interval)
+			if err == cli.ErrWatchUnsupported {
+				log.Print("jamesd does not support watching, falling back to polling")
+				watch = false
+				desired, err = client.GetDesiredStateIfChanged(labels, revision)
+			}
+		} else {
+			desired, err = client.GetDesiredStateIfChanged(labels, revision)
 		}
-		for {
-			if state, err := client.GetDesiredState(labels); err == nil {
-				log.Print("got new state")
-				e := uninstall(packetDir, installRoot, state.Apps)
-				if e != nil {
-					log.Printf("ERROR in UNINSTALL: %v", e)
-				}
-				e = install(client, packetDir, installRoot, state.Apps)
-				if e != nil {
-					log.Printf("ERROR in INSTALL: %v", e)
+		failed := err != nil
+		switch {
+		case err != nil:
+			log.Print(err)
+		case desired == nil:
+			// the desired state did not change
+			if viper.GetBool("self-heal") {
+				if e := heal(viper.GetString("packets"), viper.GetString("root")); e != nil {
+					log.Printf("ERROR in SELF-HEAL: %v", e)
 				}
+			}
+		default:
+			log.Print("got new state")
+			if _, e := reconcile(newSource(client), desired); e != nil {
+				log.Printf("ERROR in CONVERGE: %v", e)
+				failed = true
 			} else {
-				log.Print(err)
+				revision = desired.Revision
 			}
-			time.Sleep(interval)
 		}
-	},
+		if watch && !failed {
+			delay.next(false)
+			continue
+		}
+		time.Sleep(delay.next(failed))
+	}
+}
+
+// newClient returns a client for the configured addresses, the first one is preferred
+func newClient() *cli.Client {
+	addrs := make([]string, 0, 1)
+	for _, addr := range viper.GetStringSlice("addr") {
+		for _, a := range strings.Split(addr, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				addrs = append(addrs, a)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		addrs = append(addrs, "http://localhost")
+	}
+	client := cli.New(addrs[0], addrs[1:]...)
+	if token := viper.GetString("token"); token != "" {
+		client.SetToken(token)
+	}
+	return client
+}
+
+// reconcile converges to the desired state and heals the installed packets if configured
+func reconcile(src *source, desired *state.State) (*report, error) {
+	installRoot := viper.GetString("root")
+	packetDir := viper.GetString("packets")
+	installer.ScriptTimeout = viper.GetDuration("script-timeout")
+	installer.ChrootScripts = viper.GetBool("chroot-scripts")
+	res, err := converge(src, packetDir, installRoot, desired)
+	if viper.GetBool("self-heal") {
+		if e := heal(packetDir, installRoot); e != nil {
+			log.Printf("ERROR in SELF-HEAL: %v", e)
+		}
+	}
+	return res, err
 }
 
 // Execute is the main entry point
@@ -87,20 +147,42 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	RootCmd.Flags().StringVarP(&cfgFile, "config", "c", "", "config file (default is $HOME/.jamesc.yaml)")
-	RootCmd.Flags().StringP("addr", "a", "http://localhost", "jamesd address")
-	RootCmd.Flags().StringP("token", "t", "", "authorization token")
-	RootCmd.Flags().StringP("labels", "l", "", "system labels")
-	RootCmd.Flags().StringP("root", "r", "/", "install root")
-	RootCmd.Flags().StringP("packets", "p", "/var/lib/jamesc/packets", "packet directory")
-	RootCmd.Flags().DurationP("interval", "i", 30*time.Second, "check interval")
-
-	viper.BindPFlag("config", RootCmd.Flags().Lookup("config"))
-	viper.BindPFlag("addr", RootCmd.Flags().Lookup("addr"))
-	viper.BindPFlag("token", RootCmd.Flags().Lookup("token"))
-	viper.BindPFlag("root", RootCmd.Flags().Lookup("root"))
-	viper.BindPFlag("packets", RootCmd.Flags().Lookup("packets"))
-	viper.BindPFlag("interval", RootCmd.Flags().Lookup("interval"))
+	RootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default is $HOME/.jamesc.yaml)")
+	RootCmd.PersistentFlags().StringP("addr", "a", "http://localhost", "jamesd address, a comma separated list enables failover")
+	RootCmd.PersistentFlags().StringP("token", "t", "", "authorization token")
+	RootCmd.PersistentFlags().StringP("labels", "l", "", "system labels")
+	RootCmd.PersistentFlags().StringP("root", "r", "/", "install root")
+	RootCmd.PersistentFlags().StringP("packets", "p", "/var/lib/jamesc/packets", "packet directory")
+	RootCmd.PersistentFlags().DurationP("interval", "i", 30*time.Second, "check interval")
+	RootCmd.PersistentFlags().Bool("watch", true, "wait for changes of the desired state instead of polling, if jamesd supports it")
+	RootCmd.PersistentFlags().Duration("initial-delay", 30*time.Second, "maximum of the random delay before the first check")
+	RootCmd.PersistentFlags().Duration("max-backoff", 10*time.Minute, "maximum check interval when backing off after errors, at least --interval")
+	RootCmd.PersistentFlags().String("cache-dir", "/var/cache/jamesc", "download cache, can be pre-seeded with <hash>.jpk files")
+	RootCmd.PersistentFlags().Int64("cache-size", 1024, "maximum size of the download cache in MiB (0 means unbounded)")
+	RootCmd.PersistentFlags().Bool("self-heal", false, "verify installed packets every interval and reinstall them if their files drifted")
+	RootCmd.PersistentFlags().Bool("purge", false, "remove config files when uninstalling packets")
+	RootCmd.PersistentFlags().Duration("script-timeout", 5*time.Minute, "maximum runtime of maintainer scripts (0 disables the timeout)")
+	RootCmd.PersistentFlags().Bool("facts", false, "add the collected facts about the system, including hostname and machine-id, to the labels sent to jamesd")
+	RootCmd.PersistentFlags().String("facts-dir", "/etc/jamesc/facts.d", "directory with user defined facts (files or executables printing key=value lines)")
+	RootCmd.PersistentFlags().Bool("chroot-scripts", false, "run maintainer scripts chrooted into the install root")
+
+	viper.BindPFlag("config", RootCmd.PersistentFlags().Lookup("config"))
+	viper.BindPFlag("addr", RootCmd.PersistentFlags().Lookup("addr"))
+	viper.BindPFlag("token", RootCmd.PersistentFlags().Lookup("token"))
+	viper.BindPFlag("root", RootCmd.PersistentFlags().Lookup("root"))
+	viper.BindPFlag("packets", RootCmd.PersistentFlags().Lookup("packets"))
+	viper.BindPFlag("interval", RootCmd.PersistentFlags().Lookup("interval"))
+	viper.BindPFlag("watch", RootCmd.PersistentFlags().Lookup("watch"))
+	viper.BindPFlag("initial-delay", RootCmd.PersistentFlags().Lookup("initial-delay"))
+	viper.BindPFlag("max-backoff", RootCmd.PersistentFlags().Lookup("max-backoff"))
+	viper.BindPFlag("cache-dir", RootCmd.PersistentFlags().Lookup("cache-dir"))
+	viper.BindPFlag("cache-size", RootCmd.PersistentFlags().Lookup("cache-size"))
+	viper.BindPFlag("self-heal", RootCmd.PersistentFlags().Lookup("self-heal"))
+	viper.BindPFlag("purge", RootCmd.PersistentFlags().Lookup("purge"))
+	viper.BindPFlag("script-timeout", RootCmd.PersistentFlags().Lookup("script-timeout"))
+	viper.BindPFlag("facts", RootCmd.PersistentFlags().Lookup("facts"))
+	viper.BindPFlag("facts-dir", RootCmd.PersistentFlags().Lookup("facts-dir"))
+	viper.BindPFlag("chroot-scripts", RootCmd.PersistentFlags().Lookup("chroot-scripts"))
 
 }
 
@@ -118,7 +200,30 @@ func initConfig() {
 	}
 }
 
+// getLabels returns the labels of the device.
+// The configured labels override the collected facts.
 func getLabels(cmd *cobra.Command) map[string]string {
+	res := make(map[string]string)
+	if viper.GetBool("facts") {
+		facts, err := collectFacts()
+		if err != nil {
+			log.Print(err)
+		}
+		for k, v := range facts {
+			res[k] = v
+		}
+	}
+	for k, v := range getConfiguredLabels(cmd) {
+		res[k] = v
+	}
+	return res
+}
+
+func collectFacts() (map[string]string, error) {
+	return facts.Collect(viper.GetString("facts-dir"))
+}
+
+func getConfiguredLabels(cmd *cobra.Command) map[string]string {
 	labelStr, _ := cmd.Flags().GetString("labels")
 	if labelStr == "" {
 		labelStr = os.Getenv("LABELS")
@@ -136,90 +241,3 @@ func getLabels(cmd *cobra.Command) map[string]string {
 	}
 	return res
 }
-
-func checkIfInstalled(root, id string) bool {
-	installed := false
-	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		if info.Name() == id+".jpk" {
-			installed = true
-			return errors.New("") //just to break the walk
-		}
-		return nil
-	})
-	return installed
-}
-
-func collectUnneededPackets(root string, desired []*state.App) ([]string, error) {
-	res := make([]string, 0, 32)
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		if err != nil {
-			return err
-		}
-		needUninstall := true
-		for _, app := range desired {
-			if info.Name() == app.Hash+".jpk" {
-				needUninstall = false
-				break
-			}
-		}
-		if needUninstall {
-			res = append(res, info.Name())
-		}
-		return nil
-	})
-	return res, err
-}
-
-func uninstall(packetRoot, installRoot string, desired []*state.App) error {
-	uninstallPackets, err := collectUnneededPackets(packetRoot, desired)
-	if err != nil {
-		return err
-	}
-	for _, p := range uninstallPackets {
-		pack, err := packet.NewFromFile(filepath.Join(packetRoot, p))
-		if err != nil {
-			return err
-		}
-		err = installer.Uninstall(pack, installRoot)
-		if err != nil {
-			return err
-		}
-		err = os.Remove(filepath.Join(packetRoot, p))
-		if err != nil {
-			return err
-		}
-		log.Printf("uninstalled %v (%v)", pack.Name, pack.ControlInfo.Hash)
-	}
-	return nil
-}
-
-func install(cli *cli.Client, packetRoot, installRoot string, desired []*state.App) error {
-	for _, app := range desired {
-		if !checkIfInstalled(packetRoot, app.Hash) {
-			pack, err := cli.GetPacketData(app.Hash)
-			if err != nil {
-				return err
-			}
-			bs, err := pack.ToData()
-			if err != nil {
-				return err
-			}
-			err = installer.Install(pack, installRoot)
-			if err != nil {
-				return err
-			}
-			err = ioutil.WriteFile(filepath.Join(packetRoot, app.Hash+".jpk"), bs, 0655)
-			if err != nil {
-				return err
-			}
-			log.Printf("installed %v (%+v)", app.Name, app.Labels)
-		}
-	}
-	return nil
-}
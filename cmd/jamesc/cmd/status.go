@@ -0,0 +1,98 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/trusch/jamesd/installer"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// packetStatus is the status of a packet as printed by the status command
+type packetStatus struct {
+	Name        string
+	Labels      map[string]string
+	Hash        string
+	InstalledAt time.Time `yaml:"installedAt"`
+	Status      installer.Status
+	Error       string   `yaml:",omitempty"`
+	Problem     string   `yaml:",omitempty"`
+	Files       []string `yaml:",omitempty"`
+}
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "print the installed packets",
+	Long: `This prints the packets recorded in the local database together with their install time and outcome.
+Failed installations are listed with the error which occurred, installed packets whose copy in the
+packet directory is missing are listed with a problem. The system is not modified.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		installRoot := viper.GetString("root")
+		packetDir := viper.GetString("packets")
+		showFiles, _ := cmd.Flags().GetBool("files")
+		if !hasDatabase(installRoot) {
+			log.Print("no installer database found, it will be created from the packet directory by the next sync")
+		}
+		db, err := installer.OpenDatabase(installRoot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		res := make([]*packetStatus, 0, len(db.Packets))
+		for _, record := range append(db.Installed(), db.Failed()...) {
+			status := &packetStatus{
+				Name:        record.Name,
+				Labels:      record.Labels,
+				Hash:        record.Hash,
+				InstalledAt: record.InstalledAt,
+				Status:      record.Status,
+				Error:       record.Error,
+			}
+			if record.Status == installer.StatusInstalled {
+				file := filepath.Join(packetDir, record.Hash+".jpk")
+				if _, err := os.Stat(file); err != nil {
+					status.Problem = fmt.Sprintf("stored packet is unusable, it can't be uninstalled or upgraded: %v", err)
+				}
+			}
+			if showFiles {
+				status.Files = db.FilesOf(record.Hash)
+			}
+			res = append(res, status)
+		}
+		bs, err := yaml.Marshal(res)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(string(bs))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().Bool("files", false, "list the files owned by each packet")
+}
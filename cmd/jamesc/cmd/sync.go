@@ -0,0 +1,97 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// exit codes of sync --once
+const (
+	exitSuccess     = 0
+	exitFailure     = 1
+	exitNoop        = 2
+	exitUnreachable = 3
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "reconcile the installed packets with the desired state",
+	Long: `This reconciles the installed packets with the desired state computed by jamesd.
+With --once a single reconciliation is done, a summary is printed and jamesc exits with
+  0 if packets were installed, upgraded or uninstalled successfully
+  1 if some steps failed or some apps could not be resolved by jamesd
+  2 if nothing had to be done
+  3 if jamesd was unreachable`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if once, _ := cmd.Flags().GetBool("once"); !once {
+			run(cmd)
+			return
+		}
+		os.Exit(syncOnce(cmd))
+	},
+}
+
+func syncOnce(cmd *cobra.Command) int {
+	client := newClient()
+	state, err := client.GetDesiredState(getLabels(cmd))
+	if err != nil {
+		log.Print(err)
+		return requestFailure(err)
+	}
+	return summarize(reconcile(newSource(client), state))
+}
+
+// requestFailure returns the exit code for a failed request to jamesd
+func requestFailure(err error) int {
+	if _, ok := err.(*url.Error); ok {
+		return exitUnreachable
+	}
+	return exitFailure
+}
+
+// summarize prints the report of a reconciliation and returns the exit code for it
+func summarize(res *report, err error) int {
+	if res != nil {
+		fmt.Print(res)
+	}
+	switch {
+	case err != nil:
+		log.Print(err)
+		return exitFailure
+	case len(res.unresolved) > 0:
+		return exitFailure
+	case res.empty():
+		return exitNoop
+	}
+	return exitSuccess
+}
+
+func init() {
+	RootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().Bool("once", false, "reconcile once and exit")
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarize(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		res  *report
+		err  error
+		code int
+	}{
+		{"nothing to do", &report{}, nil, exitNoop},
+		{"installed", &report{installed: []string{"a"}}, nil, exitSuccess},
+		{"upgraded", &report{upgraded: []string{"a"}}, nil, exitSuccess},
+		{"uninstalled", &report{uninstalled: []string{"a"}}, nil, exitSuccess},
+		{"failed step", &report{installed: []string{"a"}, failed: []string{"b"}}, errors.New("1 of 2 steps failed"), exitFailure},
+		{"failed before converging", nil, errors.New("broken database"), exitFailure},
+	} {
+		assert.Equal(t, c.code, summarize(c.res, c.err), c.name)
+	}
+}
+
+func TestRequestFailure(t *testing.T) {
+	unreachable := &url.Error{Op: "Post", URL: "http://localhost/packet/compute", Err: errors.New("connection refused")}
+	assert.Equal(t, exitUnreachable, requestFailure(unreachable))
+	assert.Equal(t, exitFailure, requestFailure(errors.New("http error: 500")))
+}
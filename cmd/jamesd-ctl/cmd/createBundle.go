@@ -0,0 +1,103 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/trusch/jamesd/bundle"
+	"github.com/trusch/jamesd/cache"
+	"github.com/trusch/jamesd/cli"
+)
+
+// createBundleCmd represents the createBundle command
+var createBundleCmd = &cobra.Command{
+	Use:   "create",
+	Short: "create an offline bundle",
+	Long: `This resolves the desired state for a device labelset and writes it together with all packets
+it references into a single file. The bundle can be installed with 'jamesc apply-bundle' without access to jamesd.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		addr := viper.GetString("address")
+		labels := getLabels(cmd)
+		output, _ := cmd.Flags().GetString("output")
+		client := cli.New(addr)
+		token := viper.GetString("token")
+		if token != "" {
+			client.SetToken(token)
+		}
+		count, err := createBundle(client, labels, output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote %v packets to %v", count, output)
+	},
+}
+
+// createBundle writes the desired state of labels and its packets to output and returns the number of packets written
+func createBundle(client *cli.Client, labels map[string]string, output string) (int, error) {
+	state, err := client.GetDesiredState(labels)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := ioutil.TempDir("", "jamesd-bundle")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
+	downloads := cache.New(tmp, 0)
+	for _, app := range state.Unresolved {
+		log.Printf("skipping unresolved app %v: %v %v", app.Name, app.Status, app.Reason)
+	}
+	files := make(map[string]string)
+	for _, app := range state.Apps {
+		file, err := downloads.Fetch(app.Hash, client.DownloadPacket)
+		if err != nil {
+			return 0, err
+		}
+		files[app.Hash] = file
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return 0, err
+	}
+	info := &bundle.Info{Labels: labels, Created: time.Now(), State: state}
+	if err = bundle.Create(f, info, files); err != nil {
+		f.Close()
+		os.Remove(output)
+		return 0, err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(output)
+		return 0, err
+	}
+	return len(files), nil
+}
+
+func init() {
+	bundleCmd.AddCommand(createBundleCmd)
+	createBundleCmd.Flags().StringSliceP("labels", "l", []string{}, "comma separated list of labels: foo=bar,baz=quy...")
+	createBundleCmd.Flags().StringP("output", "o", "bundle.jbundle", "output file")
+}
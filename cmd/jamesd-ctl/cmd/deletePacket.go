@@ -44,7 +44,8 @@ var deletePacketCmd = &cobra.Command{
 		if token != "" {
 			client.SetToken(token)
 		}
-		if err := client.DeletePacket(id); err != nil {
+		force, _ := cmd.Flags().GetBool("force")
+		if err := client.DeletePacket(id, force); err != nil {
 			log.Fatal(err)
 		}
 	},
@@ -53,4 +54,5 @@ var deletePacketCmd = &cobra.Command{
 func init() {
 	packetCmd.AddCommand(deletePacketCmd)
 	deletePacketCmd.Flags().String("id", "", "id of the packet")
+	deletePacketCmd.Flags().Bool("force", false, "delete the packet even if it is the best match of a spec")
 }
@@ -0,0 +1,57 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+// filesPacketCmd represents the filesPacket command
+var filesPacketCmd = &cobra.Command{
+	Use:   "files",
+	Short: "list the files of a packet",
+	Long:  `This lists all paths of a packet together with their type, mode, ownership, size and sha256 hash.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pack, err := getPacketFromFileOrID(cmd, args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		manifest, err := pack.GetManifest()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, entry := range manifest {
+			fmt.Printf("%v\t%v\t%v/%v\t%v\t%v\t%v", entry.Type, entry.Mode, entry.UID, entry.GID, entry.Size, entry.SHA256, entry.Path)
+			if entry.Link != "" {
+				fmt.Printf(" -> %v", entry.Link)
+			}
+			fmt.Println()
+		}
+	},
+}
+
+func init() {
+	packetCmd.AddCommand(filesPacketCmd)
+	filesPacketCmd.Flags().StringP("file", "f", "", "packet filename")
+}
@@ -21,7 +21,9 @@
 package cmd
 
 import (
+	"io/ioutil"
 	"log"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -60,3 +62,18 @@ func getPacketByID(cmd *cobra.Command, args []string) (*packet.Packet, error) {
 	}
 	return client.GetPacketData(id)
 }
+
+func getPacketFromFileOrID(cmd *cobra.Command, args []string) (*packet.Packet, error) {
+	file, _ := cmd.Flags().GetString("file")
+	if file == "" && len(args) > 0 && strings.HasSuffix(args[0], ".jpk") {
+		file = args[0]
+	}
+	if file == "" {
+		return getPacketByID(cmd, args)
+	}
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return packet.NewFromData(bs)
+}
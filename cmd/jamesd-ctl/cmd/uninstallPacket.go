@@ -34,7 +34,7 @@ import (
 var uninstallPacketCmd = &cobra.Command{
 	Use:   "uninstall",
 	Short: "uninstall a packet",
-	Long:  `This uninstalls a packet from your system.`,
+	Long:  `This uninstalls a packet from your system. Config files are kept unless --purge is given.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		file, _ := cmd.Flags().GetString("file")
 		if file == "" {
@@ -62,7 +62,11 @@ var uninstallPacketCmd = &cobra.Command{
 		}
 		pack.Hash()
 		root, _ := cmd.Flags().GetString("root")
-		if err := installer.Uninstall(pack, root); err != nil {
+		uninstall := installer.Uninstall
+		if purge, _ := cmd.Flags().GetBool("purge"); purge {
+			uninstall = installer.Purge
+		}
+		if err := uninstall(pack, root); err != nil {
 			log.Fatal(err)
 		}
 	},
@@ -72,4 +76,5 @@ func init() {
 	packetCmd.AddCommand(uninstallPacketCmd)
 	uninstallPacketCmd.Flags().StringP("file", "f", "", "packet filename")
 	uninstallPacketCmd.Flags().StringP("root", "r", "/", "install root")
+	uninstallPacketCmd.Flags().Bool("purge", false, "remove config files too")
 }
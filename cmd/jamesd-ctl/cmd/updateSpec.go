@@ -56,7 +56,8 @@ var updateSpecCmd = &cobra.Command{
 		if err := yaml.Unmarshal(bs, &s); err != nil {
 			log.Fatal(err)
 		}
-		if err := client.PutSpec(&s); err != nil {
+		force, _ := cmd.Flags().GetBool("force")
+		if err := client.PutSpec(&s, force); err != nil {
 			log.Fatal(err)
 		}
 	},
@@ -65,4 +66,5 @@ var updateSpecCmd = &cobra.Command{
 func init() {
 	specCmd.AddCommand(updateSpecCmd)
 	updateSpecCmd.Flags().StringP("file", "f", "", "spec file")
+	updateSpecCmd.Flags().Bool("force", false, "save the spec even if apps don't resolve for its target")
 }
@@ -55,7 +55,8 @@ var uploadSpecCmd = &cobra.Command{
 		if err := yaml.Unmarshal(bs, &s); err != nil {
 			log.Fatal(err)
 		}
-		if err := client.UploadSpec(&s); err != nil {
+		force, _ := cmd.Flags().GetBool("force")
+		if err := client.UploadSpec(&s, force); err != nil {
 			log.Fatal(err)
 		}
 	},
@@ -64,4 +65,5 @@ var uploadSpecCmd = &cobra.Command{
 func init() {
 	specCmd.AddCommand(uploadSpecCmd)
 	uploadSpecCmd.Flags().StringP("file", "f", "", "spec file")
+	uploadSpecCmd.Flags().Bool("force", false, "save the spec even if apps don't resolve for its target")
 }
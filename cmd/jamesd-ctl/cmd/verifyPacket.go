@@ -0,0 +1,104 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/trusch/jamesd/installer"
+	"github.com/trusch/jamesd/packet"
+)
+
+// verifyPacketCmd represents the verifyPacket command
+var verifyPacketCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "verify installed packets",
+	Long: `This checks the files of installed packets for missing, modified and permission-changed files.
+If no packet is given, all packets installed by jamesc are verified.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		root, _ := cmd.Flags().GetString("root")
+		file, _ := cmd.Flags().GetString("file")
+		id, _ := cmd.Flags().GetString("id")
+		var packs []*packet.Packet
+		if file != "" || id != "" || len(args) > 0 {
+			pack, err := getPacketFromFileOrID(cmd, args)
+			if err != nil {
+				log.Fatal(err)
+			}
+			packs = append(packs, pack)
+		} else {
+			packetDir, _ := cmd.Flags().GetString("packets")
+			p, err := loadInstalledPackets(packetDir, root)
+			if err != nil {
+				log.Fatal(err)
+			}
+			packs = p
+		}
+		drifted := false
+		for _, pack := range packs {
+			hash, _ := pack.Hash()
+			drifts, err := installer.Verify(pack, root)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(drifts) == 0 {
+				fmt.Printf("%v (%v): ok\n", pack.Name, hash)
+				continue
+			}
+			drifted = true
+			for _, drift := range drifts {
+				fmt.Printf("%v (%v): %v %v\n", pack.Name, hash, drift.Problem, filepath.Join(root, drift.Path))
+			}
+		}
+		if drifted {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	packetCmd.AddCommand(verifyPacketCmd)
+	verifyPacketCmd.Flags().StringP("file", "f", "", "packet filename")
+	verifyPacketCmd.Flags().StringP("root", "r", "/", "install root")
+	verifyPacketCmd.Flags().StringP("packets", "p", "/var/lib/jamesc/packets", "packet directory of jamesc")
+}
+
+// loadInstalledPackets loads the packets recorded as installed in the database of an install root
+func loadInstalledPackets(dir, root string) ([]*packet.Packet, error) {
+	db, err := installer.OpenDatabase(root)
+	if err != nil {
+		return nil, err
+	}
+	records := db.Installed()
+	res := make([]*packet.Packet, 0, len(records))
+	for _, record := range records {
+		pack, err := packet.NewFromFile(filepath.Join(dir, record.Hash+".jpk"))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, pack)
+	}
+	return res, nil
+}
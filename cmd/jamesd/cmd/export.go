@@ -0,0 +1,71 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/trusch/jamesd/backup"
+	"github.com/trusch/jamesd/db"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export all packets and specs into a backup file",
+	Long: `This writes all packets, specs and their metadata from the database into a tar archive.
+The archive can be restored into any jamesd database using "jamesd import".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dbURI := viper.GetString("database")
+		output, _ := cmd.Flags().GetString("output")
+		log.Printf("connecting to %v...", dbURI)
+		db, err := db.New(dbURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tmp := output + ".part"
+		f, err := os.Create(tmp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		report, err := backup.Export(db, f)
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			log.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			os.Remove(tmp)
+			log.Fatal(err)
+		}
+		if err = os.Rename(tmp, output); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("exported %v packets and %v specs to %v", report.Packets, report.Specs, output)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringP("output", "o", "backup.tar", "backup file to write")
+}
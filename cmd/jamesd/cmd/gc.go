@@ -0,0 +1,84 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/trusch/jamesd/db"
+	"github.com/trusch/jamesd/gc"
+)
+
+// gcCmd represents the gc command
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "delete packets which are not needed anymore",
+	Long: `This deletes old packets according to a retention policy.
+Packets are grouped by name and all labels but "version", the newest --keep versions of each group are kept.
+Packets without version label and packets referenced or resolved by any spec are never deleted.
+Specs are only resolved for their target labels: a device with more labels, like its architecture,
+may resolve to another packet, which is only protected by --keep. So at least one version is always kept.
+With --interval the garbage collection runs periodically.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dbURI := viper.GetString("database")
+		keep, _ := cmd.Flags().GetInt("keep")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		log.Printf("connecting to %v...", dbURI)
+		db, err := db.New(dbURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+		policy := gc.Policy{KeepVersions: keep}
+		for {
+			report, err := gc.Collect(db, policy, dryRun)
+			if report != nil {
+				action := "deleted"
+				if dryRun {
+					action = "would delete"
+				}
+				for _, info := range report.Deleted {
+					log.Printf("%v %v %v (%v)", action, info.Name, info.Labels, info.Hash)
+				}
+				log.Printf("kept %v packets, %v %v packets", len(report.Kept), action, len(report.Deleted))
+			}
+			if err != nil {
+				log.Printf("ERROR in GC: %v", err)
+			}
+			if interval <= 0 {
+				if err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+			time.Sleep(interval)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(gcCmd)
+	gcCmd.Flags().IntP("keep", "k", 5, "number of versions to keep per packet name and labels, at least 1")
+	gcCmd.Flags().BoolP("dry-run", "n", false, "only report what would be deleted")
+	gcCmd.Flags().DurationP("interval", "i", 0, "run the garbage collection every interval (0 runs once)")
+}
@@ -0,0 +1,111 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/trusch/jamesd/cli"
+	"github.com/trusch/jamesd/db"
+	"github.com/trusch/jamesd/mirror"
+)
+
+// mirrorCmd represents the mirror command
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "replicate packets and specs from an upstream jamesd",
+	Long: `This replicates the packets and specs of an upstream jamesd into the database every interval.
+With --labels only packets and specs which apply to devices with these labels are replicated.
+Packets and specs which were changed locally are reported as conflicts and never overwritten.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dbURI := viper.GetString("database")
+		upstream, _ := cmd.Flags().GetString("upstream")
+		token, _ := cmd.Flags().GetString("token")
+		labelStr, _ := cmd.Flags().GetString("labels")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		stateFile, _ := cmd.Flags().GetString("state")
+		deleteRemoved, _ := cmd.Flags().GetBool("delete")
+		log.Printf("connecting to %v...", dbURI)
+		db, err := db.New(dbURI)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client := cli.New(upstream)
+		if token != "" {
+			client.SetToken(token)
+		}
+		m := &mirror.Mirror{
+			Upstream:  client,
+			Store:     db,
+			Labels:    parseLabels(labelStr),
+			Delete:    deleteRemoved,
+			StateFile: stateFile,
+		}
+		for {
+			report, err := m.Sync()
+			if report != nil {
+				log.Printf("mirrored %v packets and %v specs from %v, deleted %v", report.Packets, report.Specs, upstream, report.Deleted)
+				for _, conflict := range report.Conflicts {
+					log.Printf("CONFLICT: %v", conflict)
+				}
+			}
+			if err != nil {
+				log.Printf("ERROR in MIRROR: %v", err)
+			}
+			if interval <= 0 {
+				if err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+			time.Sleep(interval)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(mirrorCmd)
+	mirrorCmd.Flags().StringP("upstream", "u", "http://localhost", "upstream jamesd address")
+	mirrorCmd.Flags().StringP("token", "t", "", "authorization token for the upstream jamesd")
+	mirrorCmd.Flags().StringP("labels", "l", "", "only mirror what applies to these labels: foo=bar,baz=quy...")
+	mirrorCmd.Flags().DurationP("interval", "i", 5*time.Minute, "replication interval (0 mirrors once)")
+	mirrorCmd.Flags().String("state", "/var/lib/jamesd/mirror.json", "file remembering what was mirrored")
+	mirrorCmd.Flags().Bool("delete", false, "delete mirrored packets and specs which were deleted upstream")
+}
+
+// parseLabels parses labels in the form "key1=value1,key2=value2"
+func parseLabels(str string) map[string]string {
+	res := make(map[string]string)
+	if str == "" {
+		return res
+	}
+	for _, pair := range strings.Split(str, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 {
+			res[parts[0]] = parts[1]
+		}
+	}
+	return res
+}
@@ -0,0 +1,62 @@
+// Copyright © 2017 Tino Rusch
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/trusch/jamesd/cli"
+	"github.com/trusch/jamesd/http"
+)
+
+// proxyCmd represents the proxy command
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "start a caching proxy for an upstream jamesd",
+	Long: `This serves /packet/compute and /packet/{hash}/data to local devices from an upstream jamesd.
+Packets are cached on disk and the last desired state of each labelset is kept,
+so the devices are served even while the upstream is unreachable.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		upstream, _ := cmd.Flags().GetString("upstream")
+		token, _ := cmd.Flags().GetString("token")
+		addr, _ := cmd.Flags().GetString("listen")
+		cacheDir, _ := cmd.Flags().GetString("cache-dir")
+		cacheSize, _ := cmd.Flags().GetInt64("cache-size")
+		client := cli.New(upstream)
+		if token != "" {
+			client.SetToken(token)
+		}
+		log.Printf("start proxying %v on %v...", upstream, addr)
+		if err := http.ListenAndServeProxy(client, cacheDir, cacheSize<<20, addr); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(proxyCmd)
+	proxyCmd.Flags().StringP("upstream", "u", "http://localhost", "upstream jamesd address")
+	proxyCmd.Flags().StringP("token", "t", "", "authorization token for the upstream jamesd")
+	proxyCmd.Flags().StringP("listen", "l", ":80", "REST server address")
+	proxyCmd.Flags().String("cache-dir", "/var/cache/jamesd", "cache directory")
+	proxyCmd.Flags().Int64("cache-size", 10240, "maximum size of the packet cache in MiB (0 means unbounded)")
+}
@@ -74,19 +74,23 @@ func (db *DB) GetPacket(hash string) (*packet.Packet, error) {
 	return pack, nil
 }
 
-// DeletePacket deletes a packet
+// DeletePacket deletes a packet.
+// The controlinfo of a packet which got replaced by SavePacket is already gone, only its data is removed then.
 func (db *DB) DeletePacket(hash string) error {
 	if err := db.db.C("packet").Remove(bson.M{"hash": hash}); err != nil {
 		log.Print("db error: ", err)
 		return err
 	}
-	if err := db.db.C("controlinfo").Remove(bson.M{"hash": hash}); err != nil {
+	if err := db.db.C("controlinfo").Remove(bson.M{"hash": hash}); err != nil && err != mgo.ErrNotFound {
 		log.Print("db error: ", err)
 		return err
 	}
 	return nil
 }
 
+// ErrNoPacketFound is returned by GetBestInfo if no packet matches the labels and by GetInfo for unknown hashes
+var ErrNoPacketFound = errors.New("no packet found")
+
 // GetBestInfo returns controlinfo which doesnt contain a label which is not in the request
 func (db *DB) GetBestInfo(name string, labels map[string]string) (*packet.ControlInfo, error) {
 	collection := db.db.C("controlinfo")
@@ -123,7 +127,7 @@ func (db *DB) GetBestInfo(name string, labels map[string]string) (*packet.Contro
 		return nil, err
 	}
 	if len(mapReduceResult) == 0 {
-		return nil, errors.New("no packet found")
+		return nil, ErrNoPacketFound
 	}
 	return mapReduceResult[0].Value, nil
 }
@@ -139,6 +143,22 @@ func (db *DB) GetInfos(name string) ([]*packet.ControlInfo, error) {
 	return infos, nil
 }
 
+// GetInfo returns the controlinfo of a packet without loading its data.
+// ErrNoPacketFound is returned if there is none, like for packets which got replaced by SavePacket.
+func (db *DB) GetInfo(hash string) (*packet.ControlInfo, error) {
+	collection := db.db.C("controlinfo")
+	info := &packet.ControlInfo{}
+	err := collection.Find(bson.M{"hash": hash}).One(info)
+	if err == mgo.ErrNotFound {
+		return nil, ErrNoPacketFound
+	}
+	if err != nil {
+		log.Print("db error: ", err)
+		return nil, err
+	}
+	return info, nil
+}
+
 // GetPacketNames returns a list of all distinct packet names
 func (db *DB) GetPacketNames() ([]string, error) {
 	collection := db.db.C("controlinfo")
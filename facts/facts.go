@@ -0,0 +1,222 @@
+package facts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Provider returns facts about the system as labels
+type Provider func() (map[string]string, error)
+
+// Builtin contains the providers collected by default
+var Builtin = []Provider{Uname, OSRelease, Libc, Hostname, MachineID}
+
+// Timeout bounds the runtime of each command run to collect facts, including the user defined providers
+var Timeout = 10 * time.Second
+
+// Collect runs the builtin providers and the user defined providers in dir.
+// Later providers override the facts of earlier ones, so user defined facts win.
+// Failing providers are logged and skipped.
+func Collect(dir string) (map[string]string, error) {
+	res := make(map[string]string)
+	for _, provider := range Builtin {
+		facts, err := provider()
+		if err != nil {
+			log.Printf("failed to collect facts: %v", err)
+			continue
+		}
+		merge(res, facts)
+	}
+	if dir == "" {
+		return res, nil
+	}
+	facts, err := FromDirectory(dir)
+	if err != nil {
+		return nil, err
+	}
+	merge(res, facts)
+	return res, nil
+}
+
+// FromDirectory collects user defined facts from a directory.
+// Executables are run and their output is parsed, other files are read directly.
+// Both are expected to contain key=value lines.
+func FromDirectory(dir string) (map[string]string, error) {
+	res := make(map[string]string)
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	for _, info := range files {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		var data []byte
+		if info.Mode()&0111 != 0 {
+			cmd, cancel := command(path)
+			data, err = cmd.Output()
+			cancel()
+		} else {
+			data, err = ioutil.ReadFile(path)
+		}
+		if err != nil {
+			log.Printf("failed to collect facts from %v: %v", path, err)
+			continue
+		}
+		merge(res, parse(bytes.NewReader(data)))
+	}
+	return res, nil
+}
+
+// Uname returns the architecture and the kernel version
+func Uname() (map[string]string, error) {
+	uts := &unix.Utsname{}
+	if err := unix.Uname(uts); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"arch":   unix.ByteSliceToString(uts.Machine[:]),
+		"kernel": unix.ByteSliceToString(uts.Release[:]),
+	}, nil
+}
+
+// OSRelease returns the id and version of the distribution from os-release
+func OSRelease() (map[string]string, error) {
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return osRelease(f), nil
+	}
+	return nil, nil
+}
+
+func osRelease(r io.Reader) map[string]string {
+	values := parse(r)
+	res := make(map[string]string)
+	if id, ok := values["ID"]; ok {
+		res["os"] = id
+	}
+	if version, ok := values["VERSION_ID"]; ok {
+		res["os-version"] = version
+	}
+	return res
+}
+
+// Libc returns the name and version of the C library
+func Libc() (map[string]string, error) {
+	// glibc reports "glibc 2.36"
+	cmd, cancel := command("getconf", "GNU_LIBC_VERSION")
+	defer cancel()
+	if out, err := cmd.Output(); err == nil {
+		fields := strings.Fields(string(out))
+		if len(fields) == 2 {
+			return map[string]string{"libc": fields[0], "libc-version": fields[1]}, nil
+		}
+	}
+	// musl prints its version to stderr when ldd is called without arguments
+	cmd, cancel = command("ldd")
+	defer cancel()
+	out, _ := cmd.CombinedOutput()
+	return libcFromLdd(out), nil
+}
+
+// command returns a command which is killed if it runs longer than Timeout, cancel releases its resources
+func command(name string, args ...string) (*exec.Cmd, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	cmd := exec.CommandContext(ctx, name, args...)
+	// don't wait for children which keep the output open after the command was killed
+	cmd.WaitDelay = time.Second
+	return cmd, cancel
+}
+
+func libcFromLdd(out []byte) map[string]string {
+	res := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "musl libc") {
+			res["libc"] = "musl"
+		}
+		if strings.HasPrefix(line, "Version ") && res["libc"] == "musl" {
+			res["libc-version"] = strings.TrimSpace(strings.TrimPrefix(line, "Version "))
+		}
+	}
+	return res
+}
+
+// Hostname returns the hostname
+func Hostname() (map[string]string, error) {
+	name, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"hostname": name}, nil
+}
+
+// MachineID returns the machine id
+func MachineID() (map[string]string, error) {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		bs, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if id := strings.TrimSpace(string(bs)); id != "" {
+			return map[string]string{"machine-id": id}, nil
+		}
+	}
+	return nil, nil
+}
+
+// parse reads key=value lines, empty lines and comments are skipped and quotes are removed
+func parse(r io.Reader) map[string]string {
+	res := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		res[key] = value
+	}
+	return res
+}
+
+func merge(dst, src map[string]string) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
@@ -0,0 +1,63 @@
+package facts
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOSRelease(t *testing.T) {
+	data := `# comment
+NAME="Debian GNU/Linux"
+ID=debian
+VERSION_ID="12"
+`
+	assert.Equal(t, map[string]string{"os": "debian", "os-version": "12"}, osRelease(strings.NewReader(data)))
+}
+
+func TestLibcFromLdd(t *testing.T) {
+	out := "musl libc (x86_64)\nVersion 1.2.4\nDynamic Program Loader\n"
+	assert.Equal(t, map[string]string{"libc": "musl", "libc-version": "1.2.4"}, libcFromLdd([]byte(out)))
+}
+
+func TestFromDirectory(t *testing.T) {
+	assert.NoError(t, os.MkdirAll("./test-facts", 0755))
+	defer os.RemoveAll("./test-facts")
+	assert.NoError(t, ioutil.WriteFile("./test-facts/10-static", []byte("site=berlin\nrack=1\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile("./test-facts/20-script", []byte("#!/bin/sh\necho rack=2\n"), 0755))
+
+	facts, err := FromDirectory("./test-facts")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"site": "berlin", "rack": "2"}, facts)
+
+	facts, err = FromDirectory("./does-not-exist")
+	assert.NoError(t, err)
+	assert.Empty(t, facts)
+}
+
+func TestFactScriptTimeout(t *testing.T) {
+	assert.NoError(t, os.MkdirAll("./test-facts", 0755))
+	defer os.RemoveAll("./test-facts")
+	assert.NoError(t, ioutil.WriteFile("./test-facts/10-static", []byte("site=berlin\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile("./test-facts/20-hanging", []byte("#!/bin/sh\necho rack=2\nsleep 60\n"), 0755))
+	defer func(timeout time.Duration) { Timeout = timeout }(Timeout)
+	Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	facts, err := FromDirectory("./test-facts")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"site": "berlin"}, facts)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestCollect(t *testing.T) {
+	facts, err := Collect("")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, facts["arch"])
+	assert.NotEmpty(t, facts["kernel"])
+	assert.NotEmpty(t, facts["hostname"])
+}
@@ -0,0 +1,205 @@
+// Package gc removes packets which are not needed anymore according to a retention policy
+package gc
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/resolve"
+	"github.com/trusch/jamesd/spec"
+)
+
+// Store is a jamesd repository, it is implemented by *db.DB
+type Store interface {
+	GetPacketNames() ([]string, error)
+	GetInfos(name string) ([]*packet.ControlInfo, error)
+	GetSpecs() ([]*spec.Spec, error)
+	DeletePacket(hash string) error
+}
+
+// Policy decides which packets are kept.
+// Packets are grouped by name and all labels but "version", the newest KeepVersions of each group are kept.
+// Packets without version label and packets referenced or resolved by any spec are kept in addition.
+// Specs are only resolved for devices with exactly their target labels, devices with more labels may
+// resolve to other packets. KeepVersions must be at least one, so the newest packet of each group survives.
+type Policy struct {
+	KeepVersions int
+}
+
+// Report lists the packets kept and deleted by a garbage collection
+type Report struct {
+	Kept    []*packet.ControlInfo
+	Deleted []*packet.ControlInfo
+}
+
+// Collect applies a policy to a store and deletes all packets which are not kept.
+// With dryRun nothing is deleted, the report shows what would have been deleted.
+func Collect(store Store, policy Policy, dryRun bool) (*Report, error) {
+	if policy.KeepVersions < 1 {
+		return nil, fmt.Errorf("invalid policy: at least one version must be kept, got %v", policy.KeepVersions)
+	}
+	specs, err := store.GetSpecs()
+	if err != nil {
+		return nil, err
+	}
+	names, err := store.GetPacketNames()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	res := &Report{}
+	for _, name := range names {
+		infos, err := store.GetInfos(name)
+		if err != nil {
+			return nil, err
+		}
+		kept, obsolete := policy.apply(infos, specs)
+		res.Kept = append(res.Kept, kept...)
+		for _, info := range obsolete {
+			if !dryRun {
+				if err := store.DeletePacket(info.Hash); err != nil {
+					return res, err
+				}
+			}
+			res.Deleted = append(res.Deleted, info)
+		}
+	}
+	return res, nil
+}
+
+// apply splits the packets of one name into the ones to keep and the obsolete ones
+func (policy Policy) apply(infos []*packet.ControlInfo, specs []*spec.Spec) (kept, obsolete []*packet.ControlInfo) {
+	protected := protectedHashes(infos, specs)
+	groups := make(map[string][]*packet.ControlInfo)
+	for _, info := range infos {
+		if _, ok := info.Labels["version"]; !ok || protected[info.Hash] {
+			kept = append(kept, info)
+			continue
+		}
+		key := groupKey(info.Labels)
+		groups[key] = append(groups[key], info)
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		group := groups[key]
+		// newest first
+		sort.SliceStable(group, func(i, j int) bool {
+			return CompareVersions(group[i].Labels["version"], group[j].Labels["version"]) > 0
+		})
+		for idx, info := range group {
+			if idx < policy.KeepVersions {
+				kept = append(kept, info)
+			} else {
+				obsolete = append(obsolete, info)
+			}
+		}
+	}
+	return kept, obsolete
+}
+
+// protectedHashes returns the packets which are referenced by the apps of a spec or resolved for its target.
+// An app references a packet if the packet carries all labels of the app.
+func protectedHashes(infos []*packet.ControlInfo, specs []*spec.Spec) map[string]bool {
+	res := make(map[string]bool)
+	for _, s := range specs {
+		for _, app := range s.Apps {
+			if len(infos) == 0 || app.Name != infos[0].Name {
+				continue
+			}
+			for _, info := range infos {
+				if len(app.Labels) > 0 && resolve.Contains(info.Labels, app.Labels) {
+					res[info.Hash] = true
+				}
+			}
+			for _, info := range resolve.BestMatches(infos, resolve.Request(app, s.Target)) {
+				res[info.Hash] = true
+			}
+		}
+	}
+	return res
+}
+
+func groupKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		if k != "version" {
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// CompareVersions compares two version strings, numeric parts are compared as numbers.
+// It returns a negative number if a < b, zero if they are equal and a positive number if a > b.
+func CompareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.ParseUint(pa[i], 10, 64)
+		nb, errB := strconv.ParseUint(pb[i], 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case errA == nil:
+			// numbers are newer than words like "rc"
+			return 1
+		case errB == nil:
+			return -1
+		default:
+			if c := strings.Compare(pa[i], pb[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	// a trailing word marks a pre-release, like 1.0-rc1 < 1.0 < 1.0.1
+	switch {
+	case len(pa) > len(pb):
+		if _, err := strconv.ParseUint(pa[len(pb)], 10, 64); err != nil {
+			return -1
+		}
+		return 1
+	case len(pa) < len(pb):
+		if _, err := strconv.ParseUint(pb[len(pa)], 10, 64); err != nil {
+			return 1
+		}
+		return -1
+	}
+	return 0
+}
+
+// versionParts splits a version into its numeric and non-numeric parts, separators are dropped
+func versionParts(version string) []string {
+	var res []string
+	current := ""
+	for _, r := range version {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if current != "" {
+				res = append(res, current)
+			}
+			current = ""
+			continue
+		}
+		if current != "" && unicode.IsDigit(r) != unicode.IsDigit(rune(current[len(current)-1])) {
+			res = append(res, current)
+			current = ""
+		}
+		current += string(r)
+	}
+	if current != "" {
+		res = append(res, current)
+	}
+	return res
+}
@@ -0,0 +1,101 @@
+package gc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/internal/storetest"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+)
+
+func info(name, hash string, labels map[string]string) *packet.ControlInfo {
+	return &packet.ControlInfo{Name: name, Hash: hash, Labels: labels}
+}
+
+func hashes(infos []*packet.ControlInfo) []string {
+	res := make([]string, 0, len(infos))
+	for _, info := range infos {
+		res = append(res, info.Hash)
+	}
+	return res
+}
+
+func TestCollect(t *testing.T) {
+	store := storetest.New()
+	store.AddInfos(
+		info("app", "v1", map[string]string{"version": "1.0"}),
+		info("app", "v2", map[string]string{"version": "1.2"}),
+		info("app", "v3", map[string]string{"version": "1.10"}),
+		info("app", "v4", map[string]string{"version": "2.0-rc1"}),
+		info("app", "arm-v1", map[string]string{"version": "1.0", "arch": "arm"}),
+		info("app", "arm-v2", map[string]string{"version": "1.2", "arch": "arm"}),
+		info("app", "unversioned", map[string]string{"channel": "nightly"}),
+		info("lib", "lib-v1", map[string]string{"version": "0.1"}),
+		info("lib", "lib-v2", map[string]string{"version": "0.2"}),
+	)
+	for _, s := range []*spec.Spec{
+		// pins an old version
+		{ID: "pinned", Target: map[string]string{"fleet": "old"}, Apps: []*spec.App{
+			{Name: "app", Labels: map[string]string{"version": "1.0"}},
+		}},
+		// references and resolves an old lib version
+		{ID: "arm", Target: map[string]string{"arch": "arm"}, Apps: []*spec.App{
+			{Name: "lib", Labels: map[string]string{"version": "0.1"}},
+		}},
+	} {
+		assert.NoError(t, store.SaveSpec(s))
+	}
+
+	report, err := Collect(store, Policy{KeepVersions: 1}, true)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"v2", "v3"}, hashes(report.Deleted))
+	assert.Len(t, store.Infos(), 9, "dry run must not delete anything")
+
+	// devices may resolve to other packets than the target of a spec, so the newest version is always kept
+	_, err = Collect(store, Policy{KeepVersions: 0}, false)
+	assert.Error(t, err)
+	assert.Len(t, store.Infos(), 9)
+
+	report, err = Collect(store, Policy{KeepVersions: 1}, false)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"v2", "v3"}, hashes(report.Deleted))
+	assert.ElementsMatch(t, []string{"v1", "v4", "arm-v1", "arm-v2", "unversioned", "lib-v1", "lib-v2"}, hashes(store.Infos()))
+}
+
+func TestProtectedHashesPrecedence(t *testing.T) {
+	infos := []*packet.ControlInfo{
+		info("app", "amd64", map[string]string{"arch": "amd64", "version": "1.0"}),
+		info("app", "arm", map[string]string{"arch": "arm", "version": "1.0"}),
+	}
+	// devices of the target are arm, like jamesd does the target wins over the app labels
+	specs := []*spec.Spec{{ID: "arm", Target: map[string]string{"arch": "arm", "version": "1.0"}, Apps: []*spec.App{
+		{Name: "app", Labels: map[string]string{"arch": "amd64"}},
+	}}}
+	protected := protectedHashes(infos, specs)
+	assert.True(t, protected["arm"])
+	assert.True(t, protected["amd64"], "the app references it by its labels")
+}
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b   string
+		result int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.2", "1.10", -1},
+		{"2.0", "1.10", 1},
+		{"1.0-rc1", "1.0", -1},
+		{"1.0", "1.0.1", -1},
+		{"1.0-rc1", "1.0-rc2", -1},
+		{"1.0a", "1.0b", -1},
+	} {
+		res := CompareVersions(c.a, c.b)
+		switch {
+		case res < 0:
+			res = -1
+		case res > 0:
+			res = 1
+		}
+		assert.Equal(t, c.result, res, "%v <=> %v", c.a, c.b)
+	}
+}
@@ -1,13 +1,19 @@
 package http
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/trusch/jamesd/db"
 	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/resolve"
 	"github.com/trusch/jamesd/spec"
 	"github.com/trusch/jamesd/state"
 )
@@ -51,6 +57,13 @@ func (srv *server) postPacket(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	err = pack.Validate()
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	err = srv.db.SavePacket(pack)
 	if err != nil {
 		log.Print(err)
@@ -58,6 +71,7 @@ func (srv *server) postPacket(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	srv.changes.notify()
 	encoder := json.NewEncoder(w)
 	w.Header().Set("Content-Type", "application/json")
 	encoder.Encode(pack.ControlInfo)
@@ -66,13 +80,38 @@ func (srv *server) postPacket(w http.ResponseWriter, r *http.Request) {
 func (srv *server) deletePacket(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	hash := vars["hash"]
-	err := srv.db.DeletePacket(hash)
+	info, err := srv.db.GetInfo(hash)
+	if err != nil && err != db.ErrNoPacketFound {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	// without controlinfo only the data of a replaced packet may be left, no spec can resolve it
+	if info != nil && !isForced(r) {
+		specs, err := srv.checkPacketDeletion(info)
+		if err != nil {
+			log.Print(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if len(specs) > 0 {
+			msg := fmt.Sprintf("packet %v (%v) is the best match of the specs %v, use force=true to delete it anyway", info.Name, hash, strings.Join(specs, ", "))
+			log.Print(msg)
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(msg))
+			return
+		}
+	}
+	err = srv.db.DeletePacket(hash)
 	if err != nil {
 		log.Print(err)
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte(err.Error()))
 		return
 	}
+	srv.changes.notify()
 }
 
 func (srv *server) getPacketData(w http.ResponseWriter, r *http.Request) {
@@ -92,8 +131,11 @@ func (srv *server) getPacketData(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	// packets are addressed by the hash of their content, so they never change
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(bs)
+	w.Header().Set("ETag", `"`+hash+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, hash+".jpk", time.Time{}, bytes.NewReader(bs))
 }
 
 func (srv *server) getPacketInfo(w http.ResponseWriter, r *http.Request) {
@@ -121,35 +163,69 @@ func (srv *server) computePacketList(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
-	s, err := srv.db.GetMergedSpec(labels)
+	desiredState, err := srv.computeState(labels)
 	if err != nil {
 		log.Print(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
+	etag := `"` + desiredState.Revision + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	encoder := json.NewEncoder(w)
+	w.Header().Set("Content-Type", "application/json")
+	encoder.Encode(desiredState)
+}
+
+// matchesETag checks whether an If-None-Match header contains the given etag
+func matchesETag(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// computeState resolves the merged spec of a labelset to the best matching packets.
+// Apps which can't be resolved are listed as unresolved with their requested labels and a status
+// telling why, so a single missing packet does not block the other apps.
+func (srv *server) computeState(labels map[string]string) (*state.State, error) {
+	s, err := srv.db.GetMergedSpec(labels)
+	if err != nil {
+		return nil, err
+	}
 	desiredState := &state.State{}
 	for _, app := range s.Apps {
-		app.MergeLabels(labels)
+		app.Labels = resolve.Request(app, labels)
 		info, err := srv.db.GetBestInfo(app.Name, app.Labels)
 		if err != nil {
-			log.Print(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
+			log.Printf("failed to resolve %v for %v: %v", app.Name, labels, err)
+			desired := &state.App{App: app, Status: state.StatusError, Reason: err.Error()}
+			if err == db.ErrNoPacketFound {
+				desired.Status = state.StatusNoMatch
+			}
+			desiredState.Unresolved = append(desiredState.Unresolved, desired)
+			continue
 		}
 		desired := &state.App{
 			App: &spec.App{
 				Name:   info.Name,
 				Labels: info.Labels,
 			},
-			Hash: info.Hash,
+			Hash:   info.Hash,
+			Status: state.StatusResolved,
 		}
 		desiredState.Apps = append(desiredState.Apps, desired)
 	}
-	encoder := json.NewEncoder(w)
-	w.Header().Set("Content-Type", "application/json")
-	encoder.Encode(desiredState)
+	desiredState.Revision = desiredState.ComputeRevision()
+	return desiredState, nil
 }
 
 func (srv *server) listSpecs(w http.ResponseWriter, r *http.Request) {
@@ -175,6 +251,9 @@ func (srv *server) postSpec(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	if !srv.validateSpec(w, r, s) {
+		return
+	}
 	err = srv.db.SaveSpec(s)
 	if err != nil {
 		log.Print(err)
@@ -182,6 +261,7 @@ func (srv *server) postSpec(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	srv.changes.notify()
 	encoder := json.NewEncoder(w)
 	w.Header().Set("Content-Type", "application/json")
 	encoder.Encode(s)
@@ -229,6 +309,9 @@ func (srv *server) putSpec(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	if !srv.validateSpec(w, r, clientSpec) {
+		return
+	}
 	err = srv.db.SaveSpec(clientSpec)
 	if err != nil {
 		log.Print(err)
@@ -236,6 +319,7 @@ func (srv *server) putSpec(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	srv.changes.notify()
 	encoder := json.NewEncoder(w)
 	w.Header().Set("Content-Type", "application/json")
 	encoder.Encode(clientSpec)
@@ -251,6 +335,7 @@ func (srv *server) deleteSpec(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	srv.changes.notify()
 }
 
 func (srv *server) getSpec(w http.ResponseWriter, r *http.Request) {
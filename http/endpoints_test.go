@@ -0,0 +1,158 @@
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/internal/storetest"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+	"github.com/trusch/jamesd/state"
+)
+
+func serve(srv *server, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	srv.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestComputePacketListETag(t *testing.T) {
+	store := storetest.New()
+	assert.NoError(t, store.SavePacket(storetest.NewPacket(t, packet.ControlInfo{Name: "app"}, map[string]string{"content": "v1"})))
+	assert.NoError(t, store.SaveSpec(&spec.Spec{ID: "all", Target: map[string]string{}, Apps: []*spec.App{spec.NewApp("app")}}))
+	srv := newServer(store)
+	compute := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/packet/compute", bytes.NewBufferString(`{"arch":"amd64"}`))
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serve(srv, req)
+	}
+
+	first := compute("")
+	assert.Equal(t, http.StatusOK, first.Code)
+	etag := first.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, "no-cache", first.Header().Get("Cache-Control"))
+
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		res := compute(ifNoneMatch)
+		assert.Equal(t, http.StatusNotModified, res.Code, ifNoneMatch)
+		assert.Empty(t, res.Body.String(), ifNoneMatch)
+		assert.Equal(t, etag, res.Header().Get("ETag"), ifNoneMatch)
+	}
+
+	assert.Equal(t, http.StatusOK, compute(`"other"`).Code)
+
+	// a new packet changes the revision, so the old etag does not match anymore
+	assert.NoError(t, store.SavePacket(storetest.NewPacket(t, packet.ControlInfo{Name: "app"}, map[string]string{"content": "v2"})))
+	res := compute(etag)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.NotEqual(t, etag, res.Header().Get("ETag"))
+}
+
+func TestMatchesETag(t *testing.T) {
+	cases := []struct {
+		header string
+		match  bool
+	}{
+		{"", false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"abd"`, false},
+		{`abc`, false},
+		{`"x", "abc"`, true},
+		{`"x",W/"abc"`, true},
+		{`*`, true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, matchesETag(c.header, `"abc"`), c.header)
+	}
+}
+
+func TestGetPacketDataRange(t *testing.T) {
+	store := storetest.New()
+	pack := storetest.NewPacket(t, packet.ControlInfo{Name: "app"}, map[string]string{"content": "v1"})
+	assert.NoError(t, store.SavePacket(pack))
+	hash, err := pack.Hash()
+	assert.NoError(t, err)
+	data, err := pack.ToData()
+	assert.NoError(t, err)
+	srv := newServer(store)
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/packet/"+hash+"/data", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		return serve(srv, req)
+	}
+
+	full := get("", "")
+	assert.Equal(t, http.StatusOK, full.Code)
+	assert.Equal(t, data, full.Body.Bytes())
+	assert.Equal(t, `"`+hash+`"`, full.Header().Get("ETag"))
+	assert.Equal(t, "bytes", full.Header().Get("Accept-Ranges"))
+
+	partial := get("Range", "bytes=10-")
+	assert.Equal(t, http.StatusPartialContent, partial.Code)
+	body, _ := ioutil.ReadAll(partial.Body)
+	assert.Equal(t, data[10:], body)
+
+	first := get("Range", "bytes=0-9")
+	assert.Equal(t, http.StatusPartialContent, first.Code)
+	assert.Equal(t, data[:10], first.Body.Bytes())
+
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, get("Range", "bytes=999999999-").Code)
+	assert.Equal(t, http.StatusNotModified, get("If-None-Match", `"`+hash+`"`).Code)
+	assert.Equal(t, http.StatusNotFound, serve(srv, httptest.NewRequest("GET", "/packet/unknown/data", nil)).Code)
+}
+
+func TestDeletePacket(t *testing.T) {
+	store := storetest.New()
+	pack := storetest.NewPacket(t, packet.ControlInfo{Name: "app", Labels: map[string]string{"arch": "arm"}}, map[string]string{"content": "v1"})
+	assert.NoError(t, store.SavePacket(pack))
+	hash := pack.ControlInfo.Hash
+	assert.NoError(t, store.SaveSpec(&spec.Spec{ID: "arm", Target: map[string]string{"arch": "arm"}, Apps: []*spec.App{spec.NewApp("app")}}))
+	srv := newServer(store)
+
+	assert.Equal(t, http.StatusNotFound, serve(srv, httptest.NewRequest("DELETE", "/packet/unknown", nil)).Code)
+	assert.Equal(t, http.StatusConflict, serve(srv, httptest.NewRequest("DELETE", "/packet/"+hash, nil)).Code)
+	assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("DELETE", "/packet/"+hash+"?force=true", nil)).Code)
+	assert.Equal(t, 0, store.DataCount())
+
+	// the data of a replaced packet is left without controlinfo and can still be deleted
+	old := storetest.NewPacket(t, packet.ControlInfo{Name: "app", Labels: map[string]string{"arch": "arm"}}, map[string]string{"content": "v2"})
+	assert.NoError(t, store.SavePacket(old))
+	assert.NoError(t, store.SavePacket(storetest.NewPacket(t, packet.ControlInfo{Name: "app", Labels: map[string]string{"arch": "arm"}}, map[string]string{"content": "v3"})))
+	assert.Equal(t, 2, store.DataCount())
+	assert.Equal(t, http.StatusOK, serve(srv, httptest.NewRequest("DELETE", "/packet/"+old.ControlInfo.Hash, nil)).Code)
+	assert.Equal(t, 1, store.DataCount())
+	assert.False(t, store.HasData(old.ControlInfo.Hash))
+	assert.Equal(t, http.StatusNotFound, serve(srv, httptest.NewRequest("DELETE", "/packet/"+old.ControlInfo.Hash, nil)).Code)
+	assert.Equal(t, 0, store.Reads(), "the packet data must not be loaded")
+}
+
+func TestComputeStateUnresolved(t *testing.T) {
+	store := storetest.New()
+	assert.NoError(t, store.SavePacket(storetest.NewPacket(t, packet.ControlInfo{Name: "app"}, map[string]string{"content": "v1"})))
+	assert.NoError(t, store.SaveSpec(&spec.Spec{ID: "all", Target: map[string]string{}, Apps: []*spec.App{spec.NewApp("app"), spec.NewApp("missing")}}))
+	srv := newServer(store)
+
+	s, err := srv.computeState(map[string]string{"arch": "amd64"})
+	assert.NoError(t, err)
+	// clients which don't know about unresolved apps must only see the resolved ones
+	if assert.Len(t, s.Apps, 1) {
+		assert.Equal(t, "app", s.Apps[0].Name)
+		assert.NotEmpty(t, s.Apps[0].Hash)
+	}
+	if assert.Len(t, s.Unresolved, 1) {
+		assert.Equal(t, "missing", s.Unresolved[0].Name)
+		assert.Equal(t, state.StatusNoMatch, s.Unresolved[0].Status)
+		assert.Equal(t, "amd64", s.Unresolved[0].Labels["arch"])
+	}
+	assert.Equal(t, s.ComputeRevision(), s.Revision)
+}
@@ -0,0 +1,135 @@
+package http
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/resolve"
+	"github.com/trusch/jamesd/spec"
+)
+
+// isForced returns true if the request asks to skip the integrity checks using ?force=true
+func isForced(r *http.Request) bool {
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	return force
+}
+
+// checkSpec returns the apps of a spec which can't be resolved for any device matching its target
+func (srv *server) checkSpec(s *spec.Spec) ([]string, error) {
+	infos := make(map[string][]*packet.ControlInfo)
+	for _, app := range s.Apps {
+		if _, ok := infos[app.Name]; ok {
+			continue
+		}
+		res, err := srv.db.GetInfos(app.Name)
+		if err != nil {
+			return nil, err
+		}
+		infos[app.Name] = res
+	}
+	return specProblems(s, infos), nil
+}
+
+// specProblems checks whether each app of a spec resolves to a packet for a plausible device.
+// A plausible device has the target labels of the spec and possibly more labels,
+// so a packet is a candidate if none of its labels contradicts the request.
+func specProblems(s *spec.Spec, infos map[string][]*packet.ControlInfo) []string {
+	problems := make([]string, 0)
+	for _, app := range s.Apps {
+		candidates := infos[app.Name]
+		if len(candidates) == 0 {
+			problems = append(problems, fmt.Sprintf("app %v: there is no packet named %v", app.Name, app.Name))
+			continue
+		}
+		req := resolve.Request(app, s.Target)
+		found := false
+		for _, info := range candidates {
+			if compatible(info.Labels, req) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("app %v: no packet matches %v", app.Name, req))
+		}
+	}
+	return problems
+}
+
+// validateSpec checks a spec before it is saved and writes an error response if it is invalid.
+// Forced requests are accepted, the problems are returned as Warning headers instead.
+func (srv *server) validateSpec(w http.ResponseWriter, r *http.Request, s *spec.Spec) bool {
+	problems, err := srv.checkSpec(s)
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return false
+	}
+	if len(problems) == 0 {
+		return true
+	}
+	msg := fmt.Sprintf("spec %v does not resolve for its target: %v", s.ID, strings.Join(problems, "; "))
+	log.Print(msg)
+	if !isForced(r) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(msg + ", use force=true to save it anyway"))
+		return false
+	}
+	for _, problem := range problems {
+		w.Header().Add("Warning", "199 - "+strconv.Quote(problem))
+	}
+	return true
+}
+
+// checkPacketDeletion returns the ids of the specs for whose target the packet is the best match
+func (srv *server) checkPacketDeletion(info *packet.ControlInfo) ([]string, error) {
+	specs, err := srv.db.GetSpecs()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := srv.db.GetInfos(info.Name)
+	if err != nil {
+		return nil, err
+	}
+	return resolvingSpecs(info, infos, specs), nil
+}
+
+// resolvingSpecs returns the ids of the specs which resolve to a packet for devices with exactly their target labels
+func resolvingSpecs(info *packet.ControlInfo, infos []*packet.ControlInfo, specs []*spec.Spec) []string {
+	res := make([]string, 0)
+	for _, s := range specs {
+		if resolves(s, info, infos) {
+			res = append(res, s.ID)
+		}
+	}
+	return res
+}
+
+func resolves(s *spec.Spec, info *packet.ControlInfo, infos []*packet.ControlInfo) bool {
+	for _, app := range s.Apps {
+		if app.Name != info.Name {
+			continue
+		}
+		for _, best := range resolve.BestMatches(infos, resolve.Request(app, s.Target)) {
+			if best.Hash == info.Hash {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compatible returns true if no label of a packet is set to a different value in the request
+func compatible(labels, req map[string]string) bool {
+	for k, v := range labels {
+		if val, ok := req[k]; ok && val != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+)
+
+func TestSpecProblems(t *testing.T) {
+	infos := map[string][]*packet.ControlInfo{
+		"app": {
+			{Name: "app", Hash: "arm", Labels: map[string]string{"arch": "arm", "version": "1.0"}},
+			{Name: "app", Hash: "beta", Labels: map[string]string{"channel": "beta", "version": "2.0"}},
+		},
+	}
+	s := &spec.Spec{ID: "fleet", Target: map[string]string{"arch": "arm"}, Apps: []*spec.App{
+		{Name: "app", Labels: map[string]string{"version": "1.0"}},
+	}}
+	assert.Empty(t, specProblems(s, infos))
+
+	// devices of the fleet with channel=beta get the beta packet
+	s.Target = map[string]string{"fleet": "a"}
+	s.Apps[0].Labels = map[string]string{"version": "2.0"}
+	assert.Empty(t, specProblems(s, infos))
+
+	s.Apps[0].Labels = map[string]string{"version": "3.0"}
+	s.Apps = append(s.Apps, &spec.App{Name: "missing"})
+	assert.Len(t, specProblems(s, infos), 2)
+}
+
+func TestResolvingSpecs(t *testing.T) {
+	generic := &packet.ControlInfo{Name: "app", Hash: "generic", Labels: map[string]string{}}
+	arm := &packet.ControlInfo{Name: "app", Hash: "arm", Labels: map[string]string{"arch": "arm"}}
+	infos := []*packet.ControlInfo{generic, arm}
+	specs := []*spec.Spec{
+		{ID: "arm", Target: map[string]string{"arch": "arm"}, Apps: []*spec.App{{Name: "app"}}},
+		{ID: "all", Target: map[string]string{}, Apps: []*spec.App{{Name: "app"}, {Name: "other"}}},
+		{ID: "unrelated", Target: map[string]string{}, Apps: []*spec.App{{Name: "other"}}},
+	}
+	assert.Equal(t, []string{"arm"}, resolvingSpecs(arm, infos, specs))
+	assert.Equal(t, []string{"all"}, resolvingSpecs(generic, infos, specs))
+}
+
+func TestIsForced(t *testing.T) {
+	assert.True(t, isForced(httptest.NewRequest("DELETE", "/packet/abc?force=true", nil)))
+	assert.False(t, isForced(httptest.NewRequest("DELETE", "/packet/abc", nil)))
+}
@@ -0,0 +1,198 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/trusch/jamesd/cache"
+	"github.com/trusch/jamesd/cli"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/state"
+)
+
+// proxy serves the endpoints needed by jamesc from an upstream jamesd.
+// Packets are cached on disk by their hash and the last state of each labelset is kept,
+// so devices are served even while the upstream is unreachable.
+type proxy struct {
+	handler  *mux.Router
+	upstream *cli.Client
+	packets  *cache.Cache
+	stateDir string
+	mutex    sync.Mutex
+	fetching map[string]*fetch
+}
+
+// fetch serializes the downloads of a packet, it is dropped once no request waits for it anymore
+type fetch struct {
+	sync.Mutex
+	waiting int
+}
+
+func (p *proxy) buildEndpoint() {
+	router := mux.NewRouter()
+	packetRouter := router.PathPrefix("/packet").Subrouter().StrictSlash(true)
+	packetRouter.Path("/compute").Methods("POST").HandlerFunc(p.computePacketList)
+	packetRouter.Path("/{hash}/data").Methods("GET").HandlerFunc(p.getPacketData)
+	p.handler = router
+}
+
+func (p *proxy) computePacketList(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	labels := make(map[string]string)
+	err := decoder.Decode(&labels)
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	desiredState, err := p.upstream.GetDesiredState(labels)
+	if err == nil {
+		if desiredState.Revision == "" {
+			desiredState.Revision = desiredState.ComputeRevision()
+		}
+		if e := p.saveState(labels, desiredState); e != nil {
+			log.Print(e)
+		}
+	} else {
+		log.Printf("upstream failed, serving cached state: %v", err)
+		desiredState, err = p.loadState(labels)
+		if err != nil {
+			log.Print(err)
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	etag := `"` + desiredState.Revision + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	encoder := json.NewEncoder(w)
+	w.Header().Set("Content-Type", "application/json")
+	encoder.Encode(desiredState)
+}
+
+func (p *proxy) getPacketData(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	hash := vars["hash"]
+	if err := packet.ValidateHash(hash); err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	file, err := p.fetch(hash)
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+hash+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, hash+cache.Suffix, stat.ModTime(), f)
+}
+
+// fetch returns the cached file of a packet, concurrent requests download it only once
+func (p *proxy) fetch(hash string) (string, error) {
+	p.mutex.Lock()
+	f, ok := p.fetching[hash]
+	if !ok {
+		f = &fetch{}
+		p.fetching[hash] = f
+	}
+	f.waiting++
+	p.mutex.Unlock()
+
+	f.Lock()
+	file, err := p.packets.Fetch(hash, p.upstream.DownloadPacket)
+	f.Unlock()
+
+	p.mutex.Lock()
+	f.waiting--
+	if f.waiting == 0 {
+		delete(p.fetching, hash)
+	}
+	p.mutex.Unlock()
+	return file, err
+}
+
+func (p *proxy) stateFile(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key + "=" + labels[key] + "\n"))
+	}
+	return filepath.Join(p.stateDir, hex.EncodeToString(hash.Sum(nil))+".json")
+}
+
+func (p *proxy) saveState(labels map[string]string, s *state.State) error {
+	bs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(p.stateDir, 0755); err != nil {
+		return err
+	}
+	file := p.stateFile(labels)
+	tmp := file + ".tmp"
+	if err = ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func (p *proxy) loadState(labels map[string]string) (*state.State, error) {
+	bs, err := ioutil.ReadFile(p.stateFile(labels))
+	if err != nil {
+		return nil, err
+	}
+	s := &state.State{}
+	return s, json.Unmarshal(bs, s)
+}
+
+// ListenAndServeProxy serves the packets and desired states of an upstream jamesd.
+// Packets are cached in cacheDir which is limited to cacheSize bytes, zero means unbounded.
+func ListenAndServeProxy(upstream *cli.Client, cacheDir string, cacheSize int64, addr string) error {
+	p := &proxy{
+		upstream: upstream,
+		packets:  cache.New(filepath.Join(cacheDir, "packets"), cacheSize),
+		stateDir: filepath.Join(cacheDir, "states"),
+		fetching: make(map[string]*fetch),
+	}
+	p.buildEndpoint()
+	return http.ListenAndServe(addr, p.handler)
+}
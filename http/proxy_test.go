@@ -0,0 +1,86 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/cache"
+	"github.com/trusch/jamesd/cli"
+	"github.com/trusch/jamesd/internal/storetest"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+	"github.com/trusch/jamesd/state"
+)
+
+func TestProxy(t *testing.T) {
+	defer os.RemoveAll("./test-proxy")
+	pack := storetest.NewPacket(t, packet.ControlInfo{Name: "test-packet"}, nil)
+	data, err := pack.ToData()
+	assert.NoError(t, err)
+	hash, err := packet.HashData(bytes.NewReader(data))
+	assert.NoError(t, err)
+	desired := &state.State{Apps: []*state.App{{App: &spec.App{Name: "test-packet"}, Hash: hash}}}
+	desired.Revision = desired.ComputeRevision()
+
+	downloads := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/packet/compute":
+			json.NewEncoder(w).Encode(desired)
+		case "/packet/" + hash + "/data":
+			downloads++
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	p := &proxy{
+		upstream: cli.New(upstream.URL),
+		packets:  cache.New("./test-proxy/packets", 0),
+		stateDir: "./test-proxy/states",
+		fetching: make(map[string]*fetch),
+	}
+	p.buildEndpoint()
+	srv := httptest.NewServer(p.handler)
+	defer srv.Close()
+	client := cli.New(srv.URL)
+
+	check := func() {
+		s, err := client.GetDesiredState(map[string]string{"arch": "amd64"})
+		assert.NoError(t, err)
+		assert.Equal(t, desired.Revision, s.Revision)
+		file := "./test-proxy/download.jpk"
+		os.Remove(file)
+		assert.NoError(t, client.DownloadPacket(hash, file))
+		content, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, data, content)
+	}
+	check()
+	check()
+	assert.Equal(t, 1, downloads)
+	assert.Empty(t, p.fetching)
+
+	// invalid hashes are rejected before anything is fetched
+	res, err := http.Get(srv.URL + "/packet/not-a-hash/data")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Empty(t, p.fetching)
+
+	// cached state and packets are served while the upstream is down
+	upstream.Close()
+	check()
+
+	unchanged, err := client.GetDesiredStateIfChanged(map[string]string{"arch": "amd64"}, desired.Revision)
+	assert.NoError(t, err)
+	assert.Nil(t, unchanged)
+	_, err = client.GetDesiredState(map[string]string{"arch": "arm"})
+	assert.Error(t, err)
+}
@@ -4,12 +4,30 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
-	"github.com/trusch/jamesd/db"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
 )
 
+// Store keeps the packets and specs served by jamesd, it is implemented by *db.DB
+type Store interface {
+	GetPacketNames() ([]string, error)
+	GetInfos(name string) ([]*packet.ControlInfo, error)
+	GetInfo(hash string) (*packet.ControlInfo, error)
+	GetBestInfo(name string, labels map[string]string) (*packet.ControlInfo, error)
+	GetPacket(hash string) (*packet.Packet, error)
+	SavePacket(pack *packet.Packet) error
+	DeletePacket(hash string) error
+	GetSpecs() ([]*spec.Spec, error)
+	GetSpec(id string) (*spec.Spec, error)
+	GetMergedSpec(labels map[string]string) (*spec.Spec, error)
+	SaveSpec(s *spec.Spec) error
+	DeleteSpec(id string) error
+}
+
 type server struct {
 	handler *mux.Router
-	db      *db.DB
+	db      Store
+	changes *notifier
 }
 
 func (srv *server) buildEndpoint() {
@@ -19,6 +37,7 @@ func (srv *server) buildEndpoint() {
 	packetRouter.Path("/").Methods("GET").HandlerFunc(srv.listPackets)
 	packetRouter.Path("/").Methods("POST").HandlerFunc(srv.postPacket)
 	packetRouter.Path("/compute").Methods("POST").HandlerFunc(srv.computePacketList)
+	packetRouter.Path("/watch").Methods("GET").HandlerFunc(srv.watchPacketList)
 	packetRouter.Path("/{hash}").Methods("DELETE").HandlerFunc(srv.deletePacket)
 	packetRouter.Path("/{hash}/data").Methods("GET").HandlerFunc(srv.getPacketData)
 	packetRouter.Path("/{hash}/info").Methods("GET").HandlerFunc(srv.getPacketInfo)
@@ -34,8 +53,15 @@ func (srv *server) buildEndpoint() {
 	srv.handler = router
 }
 
-func ListenAndServe(db *db.DB, addr string) error {
-	server := &server{db: db}
+func newServer(store Store) *server {
+	server := &server{db: store, changes: newNotifier()}
 	server.buildEndpoint()
+	return server
+}
+
+// ListenAndServe serves the packets and specs of a store
+func ListenAndServe(store Store, addr string) error {
+	server := newServer(store)
+	go server.changes.recheckEvery(watchRecheckInterval)
 	return http.ListenAndServe(addr, server.handler)
 }
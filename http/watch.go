@@ -0,0 +1,154 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/trusch/jamesd/state"
+)
+
+const (
+	defaultWatchTimeout = 60 * time.Second
+	maxWatchTimeout     = 10 * time.Minute
+	// watchRecheckInterval bounds the delay for changes made by other jamesd instances using the same database
+	watchRecheckInterval = 5 * time.Minute
+)
+
+// notifier wakes up all waiting watchers when packets or specs change.
+// Until the next change the computed states are shared, so all watchers of a labelset cause a single computation.
+type notifier struct {
+	mutex   sync.Mutex
+	changed chan struct{}
+	states  map[string]*sharedState
+}
+
+// sharedState is the result of a state computation, done is closed once it is available
+type sharedState struct {
+	done  chan struct{}
+	state *state.State
+	err   error
+}
+
+func newNotifier() *notifier {
+	return &notifier{changed: make(chan struct{}), states: make(map[string]*sharedState)}
+}
+
+// wait returns a channel which gets closed on the next change
+func (n *notifier) wait() <-chan struct{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.changed
+}
+
+func (n *notifier) notify() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	close(n.changed)
+	n.changed = make(chan struct{})
+	n.states = make(map[string]*sharedState)
+}
+
+// recheckEvery notifies all watchers periodically, so they pick up changes made by other jamesd instances
+func (n *notifier) recheckEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		n.notify()
+	}
+}
+
+// state returns the state of a labelset computed since the last change.
+// Only the first caller for a labelset runs compute, the others wait for its result.
+// The returned state is shared and must not be modified.
+func (n *notifier) state(labels map[string]string, compute func(map[string]string) (*state.State, error)) (*state.State, error) {
+	key := labelKey(labels)
+	n.mutex.Lock()
+	shared, ok := n.states[key]
+	if !ok {
+		shared = &sharedState{done: make(chan struct{})}
+		n.states[key] = shared
+	}
+	n.mutex.Unlock()
+	if ok {
+		<-shared.done
+		return shared.state, shared.err
+	}
+	shared.state, shared.err = compute(labels)
+	close(shared.done)
+	if shared.err != nil {
+		// don't keep errors, the next watcher tries again
+		n.mutex.Lock()
+		if n.states[key] == shared {
+			delete(n.states, key)
+		}
+		n.mutex.Unlock()
+	}
+	return shared.state, shared.err
+}
+
+func labelKey(labels map[string]string) string {
+	bs, _ := json.Marshal(labels) // maps are encoded with sorted keys
+	return string(bs)
+}
+
+// watchPacketList returns the desired state of a labelset as soon as its revision differs from the given one.
+// The labels are passed as JSON object like `{"arch":"amd64","fleet":"alpha"}`.
+// If nothing changed until the timeout, 304 is returned. Timeouts are limited to maxWatchTimeout,
+// missing or non-positive ones default to defaultWatchTimeout.
+func (srv *server) watchPacketList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	labels := make(map[string]string)
+	if l := query.Get("labels"); l != "" {
+		if err := json.Unmarshal([]byte(l), &labels); err != nil {
+			log.Print(err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	revision := query.Get("revision")
+	timeout := defaultWatchTimeout
+	if t := query.Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			log.Print(err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		timeout = d
+	}
+	switch {
+	case timeout <= 0:
+		timeout = defaultWatchTimeout
+	case timeout > maxWatchTimeout:
+		timeout = maxWatchTimeout
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		changed := srv.changes.wait()
+		desiredState, err := srv.changes.state(labels, srv.computeState)
+		if err != nil {
+			log.Print(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if desiredState.Revision != revision {
+			encoder := json.NewEncoder(w)
+			w.Header().Set("Content-Type", "application/json")
+			encoder.Encode(desiredState)
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package http
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/cli"
+	"github.com/trusch/jamesd/internal/storetest"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+)
+
+// values containing separators must survive the query string
+var watchLabels = map[string]string{"site": "a,b=c"}
+
+func newWatchTest(t *testing.T) (*server, *storetest.Memory, *cli.Client, string) {
+	store := storetest.New()
+	assert.NoError(t, store.SavePacket(storetest.NewPacket(t, packet.ControlInfo{Name: "app"}, map[string]string{"content": "v1"})))
+	assert.NoError(t, store.SaveSpec(&spec.Spec{ID: "site", Target: watchLabels, Apps: []*spec.App{spec.NewApp("app")}}))
+	srv := newServer(store)
+	ts := httptest.NewServer(srv.handler)
+	t.Cleanup(ts.Close)
+	client := cli.New(ts.URL)
+	current, err := client.GetDesiredState(watchLabels)
+	assert.NoError(t, err)
+	assert.Len(t, current.Apps, 1)
+	return srv, store, client, current.Revision
+}
+
+func TestWatchWakesOnChange(t *testing.T) {
+	srv, store, client, revision := newWatchTest(t)
+	const watchers = 5
+	before := store.Merges()
+	results := make(chan string, watchers)
+	for i := 0; i < watchers; i++ {
+		go func() {
+			s, err := client.WatchDesiredState(watchLabels, revision, time.Minute)
+			assert.NoError(t, err)
+			if assert.NotNil(t, s) {
+				results <- s.Revision
+			} else {
+				results <- ""
+			}
+		}()
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	assert.NoError(t, store.SavePacket(storetest.NewPacket(t, packet.ControlInfo{Name: "app"}, map[string]string{"content": "v2"})))
+	srv.changes.notify()
+	for i := 0; i < watchers; i++ {
+		select {
+		case res := <-results:
+			assert.NotEqual(t, revision, res)
+		case <-time.After(5 * time.Second):
+			t.Fatal("watcher was not woken up")
+		}
+	}
+	// one computation before and one after the change, shared by all watchers
+	assert.Equal(t, 2, store.Merges()-before)
+}
+
+func TestWatchTimeout(t *testing.T) {
+	_, _, client, revision := newWatchTest(t)
+	start := time.Now()
+	s, err := client.WatchDesiredState(watchLabels, revision, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Nil(t, s)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestWatchClientDisconnect(t *testing.T) {
+	srv, _, _, revision := newWatchTest(t)
+	query := url.Values{}
+	query.Set("labels", `{"site":"a,b=c"}`)
+	query.Set("revision", revision)
+	query.Set("timeout", "10m")
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/packet/watch?"+query.Encode(), nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		srv.handler.ServeHTTP(rec, req)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+		assert.Empty(t, rec.Body.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not return after the client disconnected")
+	}
+}
+
+func TestWatchInvalidLabels(t *testing.T) {
+	srv, _, _, _ := newWatchTest(t)
+	rec := httptest.NewRecorder()
+	srv.handler.ServeHTTP(rec, httptest.NewRequest("GET", "/packet/watch?labels=site%3Da", nil))
+	assert.Equal(t, 400, rec.Code)
+}
+
+func TestWatchNonPositiveTimeout(t *testing.T) {
+	srv, _, _, revision := newWatchTest(t)
+	for _, timeout := range []string{"0s", "-1s"} {
+		query := url.Values{}
+		query.Set("labels", `{"site":"a,b=c"}`)
+		query.Set("revision", revision)
+		query.Set("timeout", timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		req := httptest.NewRequest("GET", "/packet/watch?"+query.Encode(), nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		srv.handler.ServeHTTP(rec, req)
+		cancel()
+		// the watch waits for the default timeout instead of returning at once, so only the client gives up
+		assert.True(t, time.Since(start) >= 200*time.Millisecond, timeout)
+		assert.Empty(t, rec.Body.String(), timeout)
+	}
+}
@@ -0,0 +1,343 @@
+package installer
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trusch/jamesd/packet"
+)
+
+// DatabaseFile is the location of the installer database relative to the install root
+const DatabaseFile = "var/lib/jamesc/installed.json"
+
+// Database keeps track of the installed packets and the paths they own.
+// Dirs contains the directories created by the installer together with the packets using them,
+// so they can be removed once they are not needed anymore.
+type Database struct {
+	file    string
+	Packets map[string]*Record
+	Files   map[string]string
+	Dirs    map[string][]string
+}
+
+// Status is the outcome of the last installation of a packet
+type Status string
+
+const (
+	// StatusInstalled means the packet is installed
+	StatusInstalled Status = "installed"
+	// StatusFailed means the installation failed and was rolled back
+	StatusFailed Status = "failed"
+)
+
+// Record describes an installed packet or a failed attempt to install it.
+// Dirs lists the directories of the manifest which were created by installing the packet,
+// only their metadata was set by the installer.
+type Record struct {
+	Name        string
+	Labels      map[string]string
+	Hash        string
+	InstalledAt time.Time
+	Status      Status
+	Error       string   `json:",omitempty"`
+	Dirs        []string `json:",omitempty"`
+}
+
+// OpenDatabase loads the installer database of an install root
+func OpenDatabase(installRoot string) (*Database, error) {
+	db := &Database{
+		file:    filepath.Join(installRoot, DatabaseFile),
+		Packets: make(map[string]*Record),
+		Files:   make(map[string]string),
+		Dirs:    make(map[string][]string),
+	}
+	bs, err := ioutil.ReadFile(db.file)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(bs, db); err != nil {
+		return nil, err
+	}
+	if db.Dirs == nil {
+		db.Dirs = make(map[string][]string)
+	}
+	for _, record := range db.Packets {
+		if record.Status == "" {
+			record.Status = StatusInstalled
+		}
+	}
+	return db, nil
+}
+
+// Installed returns the records of all installed packets sorted by name
+func (db *Database) Installed() []*Record {
+	res := make([]*Record, 0, len(db.Packets))
+	for _, record := range db.Packets {
+		if record.Status == StatusInstalled {
+			res = append(res, record)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name == res[j].Name {
+			return res[i].Hash < res[j].Hash
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Failed returns the records of failed installations sorted by name
+func (db *Database) Failed() []*Record {
+	res := make([]*Record, 0)
+	for _, record := range db.Packets {
+		if record.Status == StatusFailed {
+			res = append(res, record)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// FilesOf returns the paths owned by a packet sorted by name
+func (db *Database) FilesOf(hash string) []string {
+	res := make([]string, 0)
+	for path, owner := range db.Files {
+		if owner == hash {
+			res = append(res, path)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Register records a packet which is already installed, e.g. by a version of jamesc without database
+func Register(pack *packet.Packet, installRoot string, installedAt time.Time) error {
+	if _, err := pack.Hash(); err != nil {
+		return err
+	}
+	manifest, err := pack.GetManifest()
+	if err != nil {
+		return err
+	}
+	db, err := OpenDatabase(installRoot)
+	if err != nil {
+		return err
+	}
+	db.add(pack, manifest)
+	db.Packets[pack.ControlInfo.Hash].InstalledAt = installedAt
+	return db.Save()
+}
+
+// Save writes the database back to disk
+func (db *Database) Save() error {
+	bs, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(db.file), 0755); err != nil {
+		return err
+	}
+	tmp := db.file + stagedSuffix
+	if err = ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, db.file)
+}
+
+// Owner returns the record of the packet owning a path or nil
+func (db *Database) Owner(path string) *Record {
+	if hash, ok := db.Files[path]; ok {
+		if record, ok := db.Packets[hash]; ok {
+			return record
+		}
+		return &Record{Hash: hash}
+	}
+	return nil
+}
+
+// add records a packet and takes over the ownership of all its paths but directories.
+// A reinstalled packet keeps the directories it created before.
+func (db *Database) add(pack *packet.Packet, manifest packet.Manifest) {
+	hash := pack.ControlInfo.Hash
+	var dirs []string
+	if record, ok := db.Packets[hash]; ok && record.Status == StatusInstalled {
+		dirs = record.Dirs
+	}
+	db.forgetFailures(pack.Name)
+	db.Packets[hash] = &Record{
+		Name:        pack.Name,
+		Labels:      pack.Labels,
+		Hash:        hash,
+		InstalledAt: time.Now(),
+		Status:      StatusInstalled,
+		Dirs:        dirs,
+	}
+	for _, entry := range manifest {
+		if entry.Type != packet.TypeDir {
+			db.Files[entry.Path] = hash
+		}
+	}
+}
+
+// remove forgets a packet and all paths it still owns
+func (db *Database) remove(hash string) {
+	delete(db.Packets, hash)
+	for path, owner := range db.Files {
+		if owner == hash {
+			delete(db.Files, path)
+		}
+	}
+}
+
+// recordFailure remembers a failed installation of a packet and returns err.
+// Only the latest failure per packet name is kept and installed packets are never replaced.
+func (db *Database) recordFailure(pack *packet.Packet, err error) error {
+	hash := pack.ControlInfo.Hash
+	if record, ok := db.Packets[hash]; ok && record.Status == StatusInstalled {
+		return err
+	}
+	db.forgetFailures(pack.Name)
+	db.Packets[hash] = &Record{
+		Name:        pack.Name,
+		Labels:      pack.Labels,
+		Hash:        hash,
+		InstalledAt: time.Now(),
+		Status:      StatusFailed,
+		Error:       err.Error(),
+	}
+	if e := db.Save(); e != nil {
+		log.Printf("failed to record installation failure of %v: %v", pack.Name, e)
+	}
+	return err
+}
+
+func (db *Database) forgetFailures(name string) {
+	for hash, record := range db.Packets {
+		if record.Name == name && record.Status == StatusFailed {
+			delete(db.Packets, hash)
+		}
+	}
+}
+
+// ownedByOther returns true if a path is owned by an installed packet other than the one with the given hash
+func (db *Database) ownedByOther(path, hash string) bool {
+	owner, ok := db.Files[path]
+	return ok && owner != hash
+}
+
+// addDirs records the directories created while installing a packet and
+// marks the already known directories of its manifest as used by it
+func (db *Database) addDirs(hash, installRoot string, created []string, manifest packet.Manifest) error {
+	root, err := filepath.Abs(installRoot)
+	if err != nil {
+		return err
+	}
+	record := db.Packets[hash]
+	for _, dir := range created {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return err
+		}
+		db.useDir(rel, hash)
+		if entry := manifest.Get(rel); entry != nil && entry.Type == packet.TypeDir && !record.created(rel) {
+			record.Dirs = append(record.Dirs, rel)
+		}
+	}
+	for _, entry := range manifest {
+		if _, ok := db.Dirs[entry.Path]; ok && entry.Type == packet.TypeDir {
+			db.useDir(entry.Path, hash)
+		}
+	}
+	return nil
+}
+
+// created returns true if the directory was created by installing the packet
+func (record *Record) created(dir string) bool {
+	for _, created := range record.Dirs {
+		if created == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *Database) useDir(dir, hash string) {
+	for _, user := range db.Dirs[dir] {
+		if user == hash {
+			return
+		}
+	}
+	db.Dirs[dir] = append(db.Dirs[dir], hash)
+}
+
+// transferDirs hands all directories used by one packet over to another one
+func (db *Database) transferDirs(from, to string) {
+	for dir, users := range db.Dirs {
+		for idx, user := range users {
+			if user == from {
+				users[idx] = to
+			}
+		}
+		db.Dirs[dir] = users
+	}
+}
+
+// releaseDirs marks the directories used by a packet as unused by it, except those for which keep returns true.
+// Directories which end up unused and empty are removed.
+func (db *Database) releaseDirs(hash, installRoot string, keep func(dir string) bool) error {
+	dirs := make([]string, 0, len(db.Dirs))
+	for dir, users := range db.Dirs {
+		for idx, user := range users {
+			if user == hash && (keep == nil || !keep(dir)) {
+				db.Dirs[dir] = append(users[:idx], users[idx+1:]...)
+				break
+			}
+		}
+		if len(db.Dirs[dir]) == 0 {
+			dirs = append(dirs, dir)
+		}
+	}
+	// remove the deepest directories first, so their parents may become empty
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], string(filepath.Separator)) > strings.Count(dirs[j], string(filepath.Separator))
+	})
+	for _, dir := range dirs {
+		path := filepath.Join(installRoot, dir)
+		empty, err := isEmptyDir(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if !empty {
+			// keep directories with foreign content, they may be removed on a later occasion
+			continue
+		}
+		if err = removeIfExists(path); err != nil {
+			return err
+		}
+		delete(db.Dirs, dir)
+	}
+	return nil
+}
+
+func isEmptyDir(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return os.IsNotExist(err), err
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,135 @@
+package installer
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const xattrPrefix = "SCHILY.xattr."
+
+// extractEntry restores a single archive entry to path including its ownership, mode, xattrs and mtime.
+// linkTarget is the path a hardlink entry should point to.
+func extractEntry(hdr *tar.Header, archive io.Reader, path, linkTarget string) error {
+	mode := hdr.FileInfo().Mode()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(path, mode.Perm()); err != nil {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		if err := writeFile(path, archive, mode.Perm()); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := removeExisting(path); err != nil {
+			return err
+		}
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		if err := removeExisting(path); err != nil {
+			return err
+		}
+		// a hardlink shares all metadata with its target, so there is nothing more to restore
+		return os.Link(linkTarget, path)
+	case tar.TypeFifo:
+		if err := removeExisting(path); err != nil {
+			return err
+		}
+		if err := unix.Mkfifo(path, uint32(mode.Perm())); err != nil {
+			return err
+		}
+	case tar.TypeChar, tar.TypeBlock:
+		if err := removeExisting(path); err != nil {
+			return err
+		}
+		devType := uint32(unix.S_IFCHR)
+		if hdr.Typeflag == tar.TypeBlock {
+			devType = unix.S_IFBLK
+		}
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(path, devType|uint32(mode.Perm()), int(dev)); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	return restoreMetadata(hdr, path)
+}
+
+func writeFile(path string, content io.Reader, perm os.FileMode) error {
+	if info, err := os.Lstat(path); err == nil && !info.Mode().IsRegular() {
+		if err = removeExisting(path); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// removeExisting removes anything but a directory at path, so it can be replaced
+func removeExisting(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+func restoreMetadata(hdr *tar.Header, path string) error {
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag != tar.TypeSymlink {
+		// chmod after chown, since chown clears the setuid and setgid bits
+		if err := os.Chmod(path, hdr.FileInfo().Mode()&modeBits); err != nil {
+			return err
+		}
+	}
+	for key, value := range hdr.PAXRecords {
+		if strings.HasPrefix(key, xattrPrefix) {
+			if err := unix.Lsetxattr(path, strings.TrimPrefix(key, xattrPrefix), []byte(value), 0); err != nil {
+				return err
+			}
+		}
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return nil
+	case tar.TypeSymlink:
+		return unix.Lutimes(path, []unix.Timeval{
+			unix.NsecToTimeval(accessTime(hdr).UnixNano()),
+			unix.NsecToTimeval(hdr.ModTime.UnixNano()),
+		})
+	default:
+		return os.Chtimes(path, accessTime(hdr), hdr.ModTime)
+	}
+}
+
+func accessTime(hdr *tar.Header) time.Time {
+	if hdr.AccessTime.IsZero() {
+		return hdr.ModTime
+	}
+	return hdr.AccessTime
+}
@@ -1,109 +1,291 @@
 package installer
 
 import (
-	"archive/tar"
-	"io"
+	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/trusch/jamesd/packet"
 )
 
 // Install installs a packet to a given root directory
 func Install(pack *packet.Packet, installRoot string) error {
-	if err := os.MkdirAll(installRoot, 0755); err != nil {
+	if err := pack.Validate(); err != nil {
 		return err
 	}
-	if pack.ControlInfo.PreInst != "" {
-		if err := execScript(pack.ControlInfo.PreInst); err != nil {
+	if len(pack.Manifest) > 0 {
+		if err := pack.Manifest.Verify(pack.Data.GetReader()); err != nil {
 			return err
 		}
 	}
-	if err := installTar(pack.Data.GetReader(), installRoot); err != nil {
+	if err := os.MkdirAll(installRoot, 0755); err != nil {
+		return err
+	}
+	db, err := OpenDatabase(installRoot)
+	if err != nil {
+		return err
+	}
+	tx, err := newTransaction(installRoot, pack, nil)
+	if err != nil {
 		return err
 	}
+	if err = checkConflicts(db, pack, tx.manifest, ""); err != nil {
+		return db.recordFailure(pack, err)
+	}
+	if pack.ControlInfo.PreInst != "" {
+		if err := execScript(pack, "preinst", installRoot, pack.ControlInfo.PreInst); err != nil {
+			return db.recordFailure(pack, abortInstall(pack, tx, "preinst", err))
+		}
+	}
+	if err := tx.stage(pack.Data.GetReader()); err != nil {
+		return db.recordFailure(pack, abortInstall(pack, tx, "extract", err))
+	}
+	if err := tx.commit(); err != nil {
+		return db.recordFailure(pack, abortInstall(pack, tx, "extract", err))
+	}
 	if pack.ControlInfo.PostInst != "" {
-		if err := execScript(pack.ControlInfo.PostInst); err != nil {
+		if err := execScript(pack, "postinst", installRoot, pack.ControlInfo.PostInst); err != nil {
+			return db.recordFailure(pack, abortInstall(pack, tx, "postinst", err))
+		}
+	}
+	if err = tx.finish(); err != nil {
+		return err
+	}
+	db.add(pack, tx.manifest)
+	if err = db.addDirs(pack.ControlInfo.Hash, installRoot, tx.createdDirs, tx.manifest); err != nil {
+		return err
+	}
+	return db.Save()
+}
+
+// abortInstall reverts a failed installation.
+// The files are restored and the maintainer scripts which already ran get their counterpart executed:
+// prerm undoes postinst and postrm undoes preinst.
+func abortInstall(pack *packet.Packet, tx *transaction, step string, err error) error {
+	res := &InstallError{Packet: pack.Name, Step: step, Err: err}
+	if step == "postinst" && pack.ControlInfo.PreRm != "" {
+		if e := execScript(pack, "prerm", tx.installRoot, pack.ControlInfo.PreRm); e != nil {
+			res.RollbackErrs = append(res.RollbackErrs, e)
+		}
+	}
+	res.RollbackErrs = append(res.RollbackErrs, tx.rollback()...)
+	if pack.ControlInfo.PostRm != "" {
+		if e := execScript(pack, "postrm", tx.installRoot, pack.ControlInfo.PostRm); e != nil {
+			res.RollbackErrs = append(res.RollbackErrs, e)
+		}
+	}
+	return res
+}
+
+// Upgrade replaces an installed packet with a new version of it.
+// In contrast to uninstalling the old and installing the new packet, only files which are
+// not shipped anymore get removed and instead of the install/remove scripts the
+// preupgrade/postupgrade scripts of the new packet run with the old and the new version as arguments.
+func Upgrade(oldPack, newPack *packet.Packet, installRoot string) error {
+	if err := newPack.Validate(); err != nil {
+		return err
+	}
+	if len(newPack.Manifest) > 0 {
+		if err := newPack.Manifest.Verify(newPack.Data.GetReader()); err != nil {
 			return err
 		}
 	}
+	oldHash, err := oldPack.Hash()
+	if err != nil {
+		return err
+	}
+	oldManifest, err := oldPack.GetManifest()
+	if err != nil {
+		return err
+	}
+	db, err := OpenDatabase(installRoot)
+	if err != nil {
+		return err
+	}
+	oldVersion, newVersion := oldPack.Labels["version"], newPack.Labels["version"]
+	tx, err := newTransaction(installRoot, newPack, oldManifest)
+	if err != nil {
+		return err
+	}
+	if err = checkConflicts(db, newPack, tx.manifest, oldHash); err != nil {
+		return db.recordFailure(newPack, err)
+	}
+	if newPack.ControlInfo.PreUpgrade != "" {
+		if err := execScript(newPack, "preupgrade", installRoot, newPack.ControlInfo.PreUpgrade, oldVersion, newVersion); err != nil {
+			return db.recordFailure(newPack, abortUpgrade(newPack, tx, "preupgrade", err))
+		}
+	}
+	if err := tx.stage(newPack.Data.GetReader()); err != nil {
+		return db.recordFailure(newPack, abortUpgrade(newPack, tx, "extract", err))
+	}
+	if err := tx.commit(); err != nil {
+		return db.recordFailure(newPack, abortUpgrade(newPack, tx, "extract", err))
+	}
+	for _, entry := range oldManifest {
+		if entry.Type == packet.TypeDir || tx.manifest.Get(entry.Path) != nil || db.ownedByOther(entry.Path, oldHash) {
+			continue
+		}
+		path, err := securePath(installRoot, entry.Path, false)
+		if err != nil {
+			return db.recordFailure(newPack, abortUpgrade(newPack, tx, "remove", err))
+		}
+		if oldPack.IsConffile(entry.Path) {
+			modified, err := isModifiedConffile(path, entry, nil)
+			if err != nil {
+				return db.recordFailure(newPack, abortUpgrade(newPack, tx, "remove", err))
+			}
+			if modified {
+				continue
+			}
+		}
+		if err = tx.remove(path); err != nil {
+			return db.recordFailure(newPack, abortUpgrade(newPack, tx, "remove", err))
+		}
+	}
+	if newPack.ControlInfo.PostUpgrade != "" {
+		if err := execScript(newPack, "postupgrade", installRoot, newPack.ControlInfo.PostUpgrade, oldVersion, newVersion); err != nil {
+			return db.recordFailure(newPack, abortUpgrade(newPack, tx, "postupgrade", err))
+		}
+	}
+	if err = tx.finish(); err != nil {
+		return err
+	}
+	newHash := newPack.ControlInfo.Hash
+	db.remove(oldHash)
+	db.add(newPack, tx.manifest)
+	db.transferDirs(oldHash, newHash)
+	if err = db.addDirs(newHash, installRoot, tx.createdDirs, tx.manifest); err != nil {
+		return err
+	}
+	err = db.releaseDirs(newHash, installRoot, func(dir string) bool {
+		return isUsedDir(dir, tx.manifest)
+	})
+	if err != nil {
+		return err
+	}
+	return db.Save()
+}
+
+// ConflictError is returned if a packet ships a path which is owned by another installed packet
+type ConflictError struct {
+	Packet string
+	Path   string
+	Owner  string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v conflicts with %v: both contain %v (declare 'replaces: [%v]' to take it over)", e.Packet, e.Owner, e.Path, e.Owner)
+}
+
+// checkConflicts makes sure pack does not overwrite paths of other installed packets.
+// Paths owned by the packet which gets upgraded or by packets pack declares to replace are fine.
+func checkConflicts(db *Database, pack *packet.Packet, manifest packet.Manifest, upgradedHash string) error {
+	hash, err := pack.Hash()
+	if err != nil {
+		return err
+	}
+	for _, entry := range manifest {
+		if entry.Type == packet.TypeDir {
+			continue
+		}
+		owner := db.Owner(entry.Path)
+		if owner == nil || owner.Hash == hash || owner.Hash == upgradedHash || pack.ReplacesPacket(owner.Name) {
+			continue
+		}
+		return &ConflictError{Packet: pack.Name, Path: entry.Path, Owner: owner.Name}
+	}
 	return nil
 }
 
-// Uninstall uninstalls a packet from a given root directory
+// abortUpgrade reverts a failed upgrade, so the old version of the packet stays in place
+func abortUpgrade(pack *packet.Packet, tx *transaction, step string, err error) error {
+	return &InstallError{Packet: pack.Name, Step: step, Err: err, RollbackErrs: tx.rollback()}
+}
+
+// Uninstall uninstalls a packet from a given root directory.
+// Config files of the packet are kept.
 func Uninstall(pack *packet.Packet, installRoot string) error {
+	return remove(pack, installRoot, false)
+}
+
+// Purge uninstalls a packet from a given root directory including its config files
+func Purge(pack *packet.Packet, installRoot string) error {
+	return remove(pack, installRoot, true)
+}
+
+func remove(pack *packet.Packet, installRoot string, purge bool) error {
+	hash, err := pack.Hash()
+	if err != nil {
+		return err
+	}
+	db, err := OpenDatabase(installRoot)
+	if err != nil {
+		return err
+	}
 	if pack.ControlInfo.PreRm != "" {
-		if err := execScript(pack.ControlInfo.PreRm); err != nil {
+		if err := execScript(pack, "prerm", installRoot, pack.ControlInfo.PreRm); err != nil {
 			return err
 		}
 	}
-	if err := uninstallTar(pack.Data.GetReader(), installRoot); err != nil {
+	if err := removeFiles(db, pack, installRoot, purge); err != nil {
+		return err
+	}
+	if err := db.releaseDirs(hash, installRoot, nil); err != nil {
 		return err
 	}
 	if pack.ControlInfo.PostRm != "" {
-		if err := execScript(pack.ControlInfo.PostRm); err != nil {
+		if err := execScript(pack, "postrm", installRoot, pack.ControlInfo.PostRm); err != nil {
 			return err
 		}
 	}
-	return nil
-}
-
-func execScript(script string) error {
-	cmd := exec.Command("/bin/sh", "-c", script)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	db.remove(hash)
+	return db.Save()
 }
 
-func installTar(archive *tar.Reader, installRoot string) error {
-	for {
-		hdr, err := archive.Next()
-		if err == io.EOF {
-			break
+// removeFiles removes all paths of a packet which are not owned by another packet
+func removeFiles(db *Database, pack *packet.Packet, installRoot string, purge bool) error {
+	manifest, err := pack.GetManifest()
+	if err != nil {
+		return err
+	}
+	for _, entry := range manifest {
+		if entry.Type == packet.TypeDir || db.ownedByOther(entry.Path, pack.ControlInfo.Hash) {
+			continue
 		}
+		path, err := securePath(installRoot, entry.Path, false)
 		if err != nil {
 			return err
 		}
-		if hdr.FileInfo().IsDir() {
-			err = os.MkdirAll(filepath.Join(installRoot, hdr.Name), os.FileMode(hdr.Mode))
-			if err != nil {
-				return err
-			}
-		} else {
-			path := filepath.Join(installRoot, hdr.Name)
-			f, e := os.Create(path)
-			if e != nil {
-				return e
+		if pack.IsConffile(entry.Path) {
+			if !purge {
+				continue
 			}
-			if _, e = io.Copy(f, archive); e != nil {
-				f.Close()
-				return e
-			}
-			e = f.Chmod(os.FileMode(hdr.Mode))
-			if e != nil {
-				f.Close()
-				return e
+			if err = removeIfExists(path + conffileSuffix); err != nil {
+				return err
 			}
-			f.Close()
+		}
+		if err = removeIfExists(path); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func uninstallTar(archive *tar.Reader, installRoot string) error {
-	for {
-		hdr, err := archive.Next()
-		if err == io.EOF {
-			break
-		}
-		if !hdr.FileInfo().IsDir() {
-			path := filepath.Join(installRoot, hdr.Name)
-			e := os.Remove(path)
-			if e != nil {
-				return e
-			}
+// isUsedDir returns whether a directory or anything below it is part of the manifest
+func isUsedDir(dir string, manifest packet.Manifest) bool {
+	prefix := dir + string(filepath.Separator)
+	for _, entry := range manifest {
+		if entry.Path == dir || strings.HasPrefix(entry.Path, prefix) {
+			return true
 		}
 	}
+	return false
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,405 @@
+package installer
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/internal/storetest"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/tatar"
+)
+
+func TestVerify(t *testing.T) {
+	pack := storetest.NewPacket(t, packet.ControlInfo{Name: "test-packet"}, map[string]string{"foo": "foo", "bar": "bar", "baz": "baz"})
+	defer os.RemoveAll("./test-root")
+	assert.NoError(t, Install(pack, "./test-root"))
+	drifts, err := Verify(pack, "./test-root")
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	assert.NoError(t, os.Remove("./test-root/foo"))
+	assert.NoError(t, ioutil.WriteFile("./test-root/bar", []byte("modified"), 0644))
+	assert.NoError(t, os.Chmod("./test-root/baz", 0600))
+	drifts, err = Verify(pack, "./test-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Drift{
+		{Path: "bar", Problem: Modified},
+		{Path: "baz", Problem: ModeChanged},
+		{Path: "foo", Problem: Missing},
+	}, drifts)
+
+	// manifest entries must not point outside of the install root
+	pack.ControlInfo.Manifest = packet.Manifest{{Path: "../test-root/foo", Type: packet.TypeFile}}
+	_, err = Verify(pack, "./test-root")
+	assert.Error(t, err)
+
+	// neither must hardlink targets
+	defer os.RemoveAll("./test-outside")
+	assert.NoError(t, os.MkdirAll("./test-outside", 0755))
+	assert.NoError(t, ioutil.WriteFile("./test-outside/file", []byte("outside"), 0644))
+	assert.NoError(t, os.Symlink("../test-outside", "./test-root/escape"))
+	pack.ControlInfo.Manifest = packet.Manifest{{Path: "bar", Type: packet.TypeHardlink, Link: "escape/file"}}
+	_, err = Verify(pack, "./test-root")
+	assert.Error(t, err)
+}
+
+func TestInstallSpecialFiles(t *testing.T) {
+	packet.InitDirectory("./test-packet", "test-packet", map[string]string{"a": "label"})
+	defer os.RemoveAll("./test-packet")
+	mtime := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, os.MkdirAll("./test-packet/data/dir", 0750))
+	assert.NoError(t, ioutil.WriteFile("./test-packet/data/dir/file", []byte("content"), 0640))
+	assert.NoError(t, os.Chtimes("./test-packet/data/dir/file", mtime, mtime))
+	assert.NoError(t, os.Symlink("dir/file", "./test-packet/data/symlink"))
+	assert.NoError(t, os.Link("./test-packet/data/dir/file", "./test-packet/data/hardlink"))
+	assert.NoError(t, syscall.Mkfifo("./test-packet/data/fifo", 0600))
+	pack, err := packet.NewFromDirectory("./test-packet")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll("./test-root")
+	assert.NoError(t, Install(pack, "./test-root"))
+	info, err := os.Stat("./test-root/dir")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+	info, err = os.Stat("./test-root/dir/file")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	assert.True(t, mtime.Equal(info.ModTime()))
+	link, err := os.Readlink("./test-root/symlink")
+	assert.NoError(t, err)
+	assert.Equal(t, "dir/file", link)
+	hardlink, err := os.Stat("./test-root/hardlink")
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(info, hardlink))
+	info, err = os.Lstat("./test-root/fifo")
+	assert.NoError(t, err)
+	assert.True(t, info.Mode()&os.ModeNamedPipe != 0)
+
+	drifts, err := Verify(pack, "./test-root")
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestExistingDirs(t *testing.T) {
+	defer os.RemoveAll("./test-root")
+	assert.NoError(t, os.MkdirAll("./test-root/etc", 0700))
+	if os.Geteuid() == 0 {
+		assert.NoError(t, os.Chown("./test-root/etc", 1234, 1234))
+	}
+	pack := storetest.NewPacket(t, packet.ControlInfo{Name: "test-packet"}, map[string]string{"etc/conf": "conf"})
+
+	assert.NoError(t, Install(pack, "./test-root"))
+	info, err := os.Stat("./test-root/etc")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	if os.Geteuid() == 0 {
+		assert.Equal(t, uint32(1234), info.Sys().(*syscall.Stat_t).Uid)
+		assert.Equal(t, uint32(1234), info.Sys().(*syscall.Stat_t).Gid)
+	}
+
+	// the metadata of the existing directory is not the packets business, so it is no drift
+	drifts, err := Verify(pack, "./test-root")
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+	assert.NoError(t, Install(pack, "./test-root"))
+	drifts, err = Verify(pack, "./test-root")
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestVerifyCreatedDirs(t *testing.T) {
+	defer os.RemoveAll("./test-root")
+	pack := storetest.NewPacket(t, packet.ControlInfo{Name: "test-packet"}, map[string]string{"opt/app": "app"})
+	assert.NoError(t, Install(pack, "./test-root"))
+	assert.NoError(t, os.Chmod("./test-root/opt", 0700))
+	drifts, err := Verify(pack, "./test-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Drift{{Path: "opt", Problem: ModeChanged}}, drifts)
+
+	// a reinstall keeps track of the directories created by the first installation
+	assert.NoError(t, Install(pack, "./test-root"))
+	drifts, err = Verify(pack, "./test-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Drift{{Path: "opt", Problem: ModeChanged}}, drifts)
+}
+
+func TestVerifyConffiles(t *testing.T) {
+	defer os.RemoveAll("./test-root")
+	ctrl := packet.ControlInfo{Name: "test-packet", Conffiles: []string{"conf", "other"}}
+	pack := storetest.NewPacket(t, ctrl, map[string]string{"conf": "conf", "other": "other"})
+	assert.NoError(t, Install(pack, "./test-root"))
+	assert.NoError(t, ioutil.WriteFile("./test-root/conf", []byte("local"), 0644))
+	assert.NoError(t, os.Chmod("./test-root/other", 0600))
+	drifts, err := Verify(pack, "./test-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Drift{{Path: "other", Problem: ModeChanged}}, drifts)
+}
+
+func createPacketFromEntries(t *testing.T, entries []*tar.Header) *packet.Packet {
+	buf := &bytes.Buffer{}
+	writer := tar.NewWriter(buf)
+	for _, hdr := range entries {
+		hdr.Mode = 0644
+		assert.NoError(t, writer.WriteHeader(hdr))
+	}
+	assert.NoError(t, writer.Close())
+	data := &tatar.Tar{}
+	_, err := data.Load(buf)
+	assert.NoError(t, err)
+	return &packet.Packet{
+		ControlInfo: packet.ControlInfo{Name: "evil-packet"},
+		Data:        data,
+	}
+}
+
+func TestPathTraversal(t *testing.T) {
+	defer os.RemoveAll("./test-root")
+	defer os.RemoveAll("./test-outside")
+	assert.NoError(t, os.MkdirAll("./test-outside", 0755))
+	outside, _ := filepath.Abs("./test-outside")
+
+	cases := [][]*tar.Header{
+		{{Name: "../test-outside/file", Typeflag: tar.TypeReg}},
+		{{Name: "/tmp/file", Typeflag: tar.TypeReg}},
+		{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../test-outside"}},
+		{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside},
+			{Name: "link/file", Typeflag: tar.TypeReg},
+		},
+		{{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "../test-outside/file"}},
+	}
+	for _, entries := range cases {
+		pack := createPacketFromEntries(t, entries)
+		assert.Error(t, pack.Validate())
+		assert.Error(t, Install(pack, "./test-root"))
+	}
+
+	// a symlink which is already on disk must not be written through either
+	assert.NoError(t, os.MkdirAll("./test-root", 0755))
+	assert.NoError(t, os.Symlink("../test-outside", "./test-root/dir"))
+	pack := createPacketFromEntries(t, []*tar.Header{{Name: "dir/file", Typeflag: tar.TypeReg}})
+	assert.NoError(t, pack.Validate())
+	assert.Error(t, Install(pack, "./test-root"))
+	_, err := os.Stat("./test-outside/file")
+	assert.True(t, os.IsNotExist(err))
+
+	// absolute symlinks are resolved relative to the install root
+	assert.NoError(t, os.Remove("./test-root/dir"))
+	assert.NoError(t, os.MkdirAll("./test-root/real", 0755))
+	assert.NoError(t, os.Symlink("/real", "./test-root/dir"))
+	assert.NoError(t, Install(pack, "./test-root"))
+	_, err = os.Stat("./test-root/real/file")
+	assert.NoError(t, err)
+}
+
+func TestRollback(t *testing.T) {
+	defer os.RemoveAll("./test-root")
+	assert.NoError(t, os.MkdirAll("./test-root/etc", 0700))
+	assert.NoError(t, ioutil.WriteFile("./test-root/foo", []byte("old"), 0644))
+
+	newPack := storetest.NewPacket(t, packet.ControlInfo{
+		Name:    "test-packet",
+		Labels:  map[string]string{"a": "label"},
+		Scripts: packet.Scripts{PostInst: "exit 1"},
+	}, map[string]string{"etc/conf": "conf", "foo": "new", "dir/subdir/bar": "bar"})
+
+	err := Install(newPack, "./test-root")
+	assert.Error(t, err)
+	installErr, ok := err.(*InstallError)
+	assert.True(t, ok)
+	if !ok {
+		return
+	}
+	assert.Equal(t, "postinst", installErr.Step)
+	assert.Empty(t, installErr.RollbackErrs)
+
+	content, err := ioutil.ReadFile("./test-root/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+	_, err = os.Stat("./test-root/dir")
+	assert.True(t, os.IsNotExist(err))
+	// pre-existing directories are neither removed nor modified
+	info, err := os.Stat("./test-root/etc")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	_, err = os.Stat("./test-root/etc/conf")
+	assert.True(t, os.IsNotExist(err))
+	files, err := ioutil.ReadDir("./test-root")
+	assert.NoError(t, err)
+	// etc, foo and the installer database
+	assert.Equal(t, 3, len(files))
+
+	db, err := OpenDatabase("./test-root")
+	assert.NoError(t, err)
+	assert.Empty(t, db.Installed())
+	failed := db.Failed()
+	if assert.Equal(t, 1, len(failed)) {
+		assert.Equal(t, StatusFailed, failed[0].Status)
+		assert.Contains(t, failed[0].Error, "postinst")
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	defer os.RemoveAll("./test-root")
+	oldPack := storetest.NewPacket(t, packet.ControlInfo{Name: "test-packet", Labels: map[string]string{"version": "1.0"}},
+		map[string]string{"foo": "old", "bar": "bar"})
+	assert.NoError(t, Install(oldPack, "./test-root"))
+
+	root, _ := filepath.Abs("./test-root")
+	newPack := storetest.NewPacket(t, packet.ControlInfo{
+		Name:    "test-packet",
+		Labels:  map[string]string{"version": "1.1"},
+		Scripts: packet.Scripts{PreUpgrade: "echo $1 $2 > " + root + "/versions"},
+	}, map[string]string{"foo": "new", "baz": "baz"})
+
+	assert.NoError(t, Upgrade(oldPack, newPack, "./test-root"))
+	content, _ := ioutil.ReadFile("./test-root/foo")
+	assert.Equal(t, "new", string(content))
+	content, _ = ioutil.ReadFile("./test-root/versions")
+	assert.Equal(t, "1.0 1.1\n", string(content))
+	_, err := os.Stat("./test-root/bar")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat("./test-root/baz")
+	assert.NoError(t, err)
+}
+
+func TestConffiles(t *testing.T) {
+	defer os.RemoveAll("./test-root")
+	createVersion := func(version string) *packet.Packet {
+		return storetest.NewPacket(t, packet.ControlInfo{
+			Name:      "test-packet",
+			Labels:    map[string]string{"version": version},
+			Conffiles: []string{"/etc/foo.conf"},
+		}, map[string]string{"etc/foo.conf": "v" + version})
+	}
+	v1 := createVersion("1")
+	v2 := createVersion("2")
+	v3 := createVersion("3")
+
+	// unmodified config files are upgraded
+	assert.NoError(t, Install(v1, "./test-root"))
+	assert.NoError(t, Upgrade(v1, v2, "./test-root"))
+	content, _ := ioutil.ReadFile("./test-root/etc/foo.conf")
+	assert.Equal(t, "v2", string(content))
+
+	// modified config files are kept, the new version is written next to it
+	assert.NoError(t, ioutil.WriteFile("./test-root/etc/foo.conf", []byte("local"), 0644))
+	drifts, err := Verify(v2, "./test-root")
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+	assert.NoError(t, Upgrade(v2, v3, "./test-root"))
+	content, _ = ioutil.ReadFile("./test-root/etc/foo.conf")
+	assert.Equal(t, "local", string(content))
+	content, _ = ioutil.ReadFile("./test-root/etc/foo.conf.new")
+	assert.Equal(t, "v3", string(content))
+
+	// uninstall keeps config files, purge removes them
+	assert.NoError(t, Uninstall(v3, "./test-root"))
+	_, err = os.Stat("./test-root/etc/foo.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, Purge(v3, "./test-root"))
+	_, err = os.Stat("./test-root/etc/foo.conf")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat("./test-root/etc/foo.conf.new")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestConflicts(t *testing.T) {
+	defer os.RemoveAll("./test-root")
+	a := storetest.NewPacket(t, packet.ControlInfo{Name: "a"}, map[string]string{"shared": "a", "a": "a"})
+	b := storetest.NewPacket(t, packet.ControlInfo{Name: "b"}, map[string]string{"shared": "b", "b": "b"})
+	c := storetest.NewPacket(t, packet.ControlInfo{Name: "c", Replaces: []string{"a"}}, map[string]string{"shared": "c", "c": "c"})
+
+	assert.NoError(t, Install(a, "./test-root"))
+	err := Install(b, "./test-root")
+	assert.Error(t, err)
+	assert.IsType(t, &ConflictError{}, err)
+	_, err = os.Stat("./test-root/b")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, Install(c, "./test-root"))
+	db, err := OpenDatabase("./test-root")
+	assert.NoError(t, err)
+	assert.Equal(t, "c", db.Owner("shared").Name)
+
+	assert.NoError(t, Uninstall(a, "./test-root"))
+	content, err := ioutil.ReadFile("./test-root/shared")
+	assert.NoError(t, err)
+	assert.Equal(t, "c", string(content))
+	_, err = os.Stat("./test-root/a")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRemoveCreatedDirs(t *testing.T) {
+	defer os.RemoveAll("./test-root")
+	assert.NoError(t, os.MkdirAll("./test-root/opt", 0755))
+	a := storetest.NewPacket(t, packet.ControlInfo{Name: "a"}, map[string]string{"opt/a/bin/a": "a", "opt/shared/a": "a"})
+	b := storetest.NewPacket(t, packet.ControlInfo{Name: "b"}, map[string]string{"opt/shared/b": "b"})
+	assert.NoError(t, Install(a, "./test-root"))
+	assert.NoError(t, Install(b, "./test-root"))
+
+	assert.NoError(t, Uninstall(a, "./test-root"))
+	_, err := os.Stat("./test-root/opt/a")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat("./test-root/opt/shared/b")
+	assert.NoError(t, err)
+
+	assert.NoError(t, Uninstall(b, "./test-root"))
+	_, err = os.Stat("./test-root/opt/shared")
+	assert.True(t, os.IsNotExist(err))
+	// directories which existed before are kept
+	_, err = os.Stat("./test-root/opt")
+	assert.NoError(t, err)
+}
+
+func TestScriptEnvironment(t *testing.T) {
+	defer os.RemoveAll("./test-root")
+	script := `echo "$JAMES_ACTION $JAMES_PACKET_NAME $JAMES_LABELS_A $JAMES_LABELS_ARCH_NAME $JAMES_INSTALL_ROOT" > env`
+	pack := storetest.NewPacket(t, packet.ControlInfo{
+		Name:    "test-packet",
+		Labels:  map[string]string{"a": "label", "arch-name": "amd64"},
+		Scripts: packet.Scripts{PostInst: script},
+	}, nil)
+
+	assert.NoError(t, Install(pack, "./test-root"))
+	root, _ := filepath.Abs("./test-root")
+	content, err := ioutil.ReadFile("./test-root/env")
+	assert.NoError(t, err)
+	assert.Equal(t, "postinst test-packet label amd64 "+root+"\n", string(content))
+}
+
+func TestScriptTimeout(t *testing.T) {
+	defer os.RemoveAll("./test-root")
+	defer func(timeout time.Duration) { ScriptTimeout = timeout }(ScriptTimeout)
+	ScriptTimeout = 100 * time.Millisecond
+	pack := storetest.NewPacket(t, packet.ControlInfo{Name: "test-packet", Scripts: packet.Scripts{PreInst: "sleep 5"}}, nil)
+
+	start := time.Now()
+	err := Install(pack, "./test-root")
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestDatabaseRecords(t *testing.T) {
+	pack := storetest.NewPacket(t, packet.ControlInfo{Name: "test-packet"}, map[string]string{"foo": "foo", "bar": "bar"})
+	defer os.RemoveAll("./test-root")
+	start := time.Now()
+	assert.NoError(t, Install(pack, "./test-root"))
+
+	db, err := OpenDatabase("./test-root")
+	assert.NoError(t, err)
+	installed := db.Installed()
+	if assert.Equal(t, 1, len(installed)) {
+		assert.Equal(t, "test-packet", installed[0].Name)
+		assert.Equal(t, StatusInstalled, installed[0].Status)
+		assert.False(t, installed[0].InstalledAt.Before(start))
+		assert.Equal(t, []string{"bar", "foo"}, db.FilesOf(installed[0].Hash))
+	}
+}
@@ -0,0 +1,72 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/trusch/jamesd/packet"
+)
+
+const maxSymlinkHops = 255
+
+// securePath returns the location of an archive entry inside of installRoot.
+// Symlinks in the parent directories (and in the entry itself if followLast is set) are
+// resolved as if installRoot was the filesystem root. Paths which would end up
+// outside of installRoot are rejected.
+func securePath(installRoot, name string, followLast bool) (string, error) {
+	if err := packet.ValidatePath(name); err != nil {
+		return "", err
+	}
+	root, err := filepath.Abs(installRoot)
+	if err != nil {
+		return "", err
+	}
+	hops := 0
+	res, err := resolve(root, root, name, followLast, &hops)
+	if err != nil {
+		return "", fmt.Errorf("invalid path %v: %v", name, err)
+	}
+	return res, nil
+}
+
+// resolve walks name component by component starting at dir, following symlinks on the way
+func resolve(root, dir, name string, followLast bool, hops *int) (string, error) {
+	name = strings.TrimPrefix(filepath.Clean(filepath.FromSlash(name)), string(filepath.Separator))
+	parts := strings.Split(name, string(filepath.Separator))
+	current := dir
+	for idx, part := range parts {
+		next := filepath.Join(current, part)
+		if !isWithin(root, next) {
+			return "", fmt.Errorf("symlink escapes the install root")
+		}
+		if idx < len(parts)-1 || followLast {
+			info, err := os.Lstat(next)
+			if err == nil && info.Mode()&os.ModeSymlink != 0 {
+				*hops++
+				if *hops > maxSymlinkHops {
+					return "", fmt.Errorf("too many levels of symbolic links")
+				}
+				link, err := os.Readlink(next)
+				if err != nil {
+					return "", err
+				}
+				start := current
+				if filepath.IsAbs(link) {
+					start = root
+				}
+				if next, err = resolve(root, start, link, true, hops); err != nil {
+					return "", err
+				}
+			}
+		}
+		current = next
+	}
+	return current, nil
+}
+
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,120 @@
+package installer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/trusch/jamesd/packet"
+)
+
+// ScriptTimeout is the maximum time a maintainer script may run, zero disables the timeout
+var ScriptTimeout = 5 * time.Minute
+
+// ChrootScripts makes maintainer scripts run chrooted into the install root.
+// The install root needs to contain a /bin/sh in that case.
+var ChrootScripts = false
+
+// execScript runs a maintainer script of a packet, args are passed as positional parameters.
+// The script runs inside the install root and gets the packet and the action described
+// via JAMES_* environment variables. Its output is written to the log.
+func execScript(pack *packet.Packet, action, installRoot, script string, args ...string) error {
+	root, err := filepath.Abs(installRoot)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("/bin/sh", append([]string{"-c", script, action}, args...)...)
+	cmd.Dir = root
+	// run in an own process group, so a timeout also kills the children of the script
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if ChrootScripts {
+		cmd.SysProcAttr.Chroot = root
+		cmd.Dir = "/"
+	}
+	cmd.Env = append(os.Environ(), scriptEnv(pack, action, cmd.Dir)...)
+	output := &logWriter{prefix: fmt.Sprintf("%v %v: ", pack.Name, action)}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("%v failed: %v", action, err)
+	}
+	var timer *time.Timer
+	if ScriptTimeout > 0 {
+		timer = time.AfterFunc(ScriptTimeout, func() {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		})
+	}
+	err = cmd.Wait()
+	output.Flush()
+	if timer != nil && !timer.Stop() {
+		return fmt.Errorf("%v timed out after %v", action, ScriptTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%v failed: %v", action, err)
+	}
+	return nil
+}
+
+// scriptEnv returns the environment variables describing the operation to a maintainer script
+func scriptEnv(pack *packet.Packet, action, installRoot string) []string {
+	hash := pack.ControlInfo.Hash
+	if hash == "" {
+		hash, _ = pack.Hash()
+	}
+	env := []string{
+		"JAMES_PACKET_NAME=" + pack.Name,
+		"JAMES_PACKET_HASH=" + hash,
+		"JAMES_INSTALL_ROOT=" + installRoot,
+		"JAMES_ACTION=" + action,
+	}
+	for key, value := range pack.Labels {
+		env = append(env, "JAMES_LABELS_"+envName(key)+"="+value)
+	}
+	return env
+}
+
+// envName turns a label key into a valid environment variable name
+func envName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, key)
+}
+
+// logWriter writes everything line by line to the log
+type logWriter struct {
+	prefix string
+	buf    bytes.Buffer
+}
+
+func (w *logWriter) Write(data []byte) (int, error) {
+	w.buf.Write(data)
+	for {
+		idx := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+		line := w.buf.Next(idx + 1)
+		log.Print(w.prefix + string(line[:idx]))
+	}
+	return len(data), nil
+}
+
+// Flush logs a trailing incomplete line
+func (w *logWriter) Flush() {
+	if w.buf.Len() > 0 {
+		log.Print(w.prefix + w.buf.String())
+		w.buf.Reset()
+	}
+}
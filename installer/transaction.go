@@ -0,0 +1,281 @@
+package installer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/trusch/jamesd/packet"
+)
+
+const (
+	stagedSuffix   = ".jamesd-new"
+	backupSuffix   = ".jamesd-old"
+	conffileSuffix = ".new"
+)
+
+// InstallError is returned if an installation failed and was rolled back
+type InstallError struct {
+	Packet       string
+	Step         string
+	Err          error
+	RollbackErrs []error
+}
+
+func (e *InstallError) Error() string {
+	msg := fmt.Sprintf("installation of %v failed in %v: %v", e.Packet, e.Step, e.Err)
+	if len(e.RollbackErrs) == 0 {
+		return msg + " (rolled back)"
+	}
+	errs := make([]string, len(e.RollbackErrs))
+	for idx, err := range e.RollbackErrs {
+		errs[idx] = err.Error()
+	}
+	return msg + " (rollback failed: " + strings.Join(errs, "; ") + ")"
+}
+
+// transaction installs the content of a data archive so that it can be reverted.
+// Every entry is first extracted next to its destination, existing files are moved
+// aside on commit and only removed once the whole installation succeeded.
+type transaction struct {
+	installRoot string
+	pack        *packet.Packet
+	manifest    packet.Manifest
+	original    packet.Manifest
+	entries     []*txEntry
+	removed     []*txEntry
+	dirs        []*txEntry
+	createdDirs []string
+	committed   int
+}
+
+type txEntry struct {
+	hdr    *tar.Header
+	path   string
+	staged string
+	backup string
+}
+
+// newTransaction prepares the installation of pack.
+// original is the manifest of the version which is currently installed, it is
+// used to detect locally modified config files.
+func newTransaction(installRoot string, pack *packet.Packet, original packet.Manifest) (*transaction, error) {
+	manifest, err := pack.GetManifest()
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{
+		installRoot: installRoot,
+		pack:        pack,
+		manifest:    manifest,
+		original:    original,
+	}, nil
+}
+
+// stage extracts all entries of the archive next to their destination.
+// Only directories are created in place, so the files can be moved in later.
+func (tx *transaction) stage(archive *tar.Reader) error {
+	staged := make(map[string]string)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		isDir := hdr.Typeflag == tar.TypeDir
+		path, err := securePath(tx.installRoot, hdr.Name, isDir)
+		if err != nil {
+			return err
+		}
+		entry := &txEntry{hdr: hdr, path: path}
+		if isDir {
+			if err = tx.mkdirAll(path, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+			if !tx.created(path) {
+				// existing directories like /etc belong to the system, their metadata is left alone
+				continue
+			}
+			if err = extractEntry(hdr, archive, path, ""); err != nil {
+				return err
+			}
+			tx.dirs = append(tx.dirs, entry)
+			continue
+		}
+		if err = tx.mkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if tx.pack.IsConffile(hdr.Name) {
+			modified, err := isModifiedConffile(path, tx.original.Get(hdr.Name), tx.manifest.Get(hdr.Name))
+			if err != nil {
+				return err
+			}
+			if modified {
+				log.Printf("keeping locally modified %v, installing the new version as %v", path, path+conffileSuffix)
+				entry.path = path + conffileSuffix
+			}
+		}
+		entry.staged = entry.path + stagedSuffix
+		tx.entries = append(tx.entries, entry)
+		if err = extractEntry(hdr, archive, entry.staged, staged[filepath.Clean(hdr.Linkname)]); err != nil {
+			return err
+		}
+		staged[filepath.Clean(hdr.Name)] = entry.staged
+	}
+	return nil
+}
+
+// commit moves all staged entries into place, keeping a backup of the replaced files
+func (tx *transaction) commit() error {
+	for _, entry := range tx.entries {
+		info, err := os.Lstat(entry.path)
+		if err == nil {
+			if info.IsDir() {
+				return fmt.Errorf("can not replace directory %v", entry.path)
+			}
+			entry.backup = entry.path + backupSuffix
+			if err = os.Rename(entry.path, entry.backup); err != nil {
+				entry.backup = ""
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if err = os.Rename(entry.staged, entry.path); err != nil {
+			return err
+		}
+		tx.committed++
+	}
+	return nil
+}
+
+// remove moves a path out of the way, it is deleted on finish or restored on rollback
+func (tx *transaction) remove(path string) error {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return nil
+	}
+	entry := &txEntry{path: path, backup: path + backupSuffix}
+	if err := os.Rename(entry.path, entry.backup); err != nil {
+		return err
+	}
+	tx.removed = append(tx.removed, entry)
+	return nil
+}
+
+// finish removes the backups and restores the mtimes of the created directories
+func (tx *transaction) finish() error {
+	for _, entry := range append(tx.entries, tx.removed...) {
+		if entry.backup != "" {
+			if err := os.Remove(entry.backup); err != nil {
+				return err
+			}
+		}
+	}
+	// directory mtimes are restored last, creating their content would modify them again
+	for i := len(tx.dirs) - 1; i >= 0; i-- {
+		dir := tx.dirs[i]
+		if err := os.Chtimes(dir.path, accessTime(dir.hdr), dir.hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback restores the state from before the transaction.
+// Pre-existing directories are never modified, so only the created ones need to be removed.
+func (tx *transaction) rollback() []error {
+	errs := make([]error, 0)
+	for i := len(tx.removed) - 1; i >= 0; i-- {
+		entry := tx.removed[i]
+		if err := os.Rename(entry.backup, entry.path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i := len(tx.entries) - 1; i >= 0; i-- {
+		entry := tx.entries[i]
+		if i < tx.committed {
+			if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		} else if err := os.Remove(entry.staged); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+		if entry.backup != "" {
+			if err := os.Rename(entry.backup, entry.path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for i := len(tx.createdDirs) - 1; i >= 0; i-- {
+		if err := os.Remove(tx.createdDirs[i]); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// mkdirAll works like os.MkdirAll but remembers every directory it created
+func (tx *transaction) mkdirAll(path string, perm os.FileMode) error {
+	info, err := os.Stat(path)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%v exists and is not a directory", path)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if parent := filepath.Dir(path); parent != path {
+		if err = tx.mkdirAll(parent, 0755); err != nil {
+			return err
+		}
+	}
+	if err = os.Mkdir(path, perm); err != nil {
+		return err
+	}
+	tx.createdDirs = append(tx.createdDirs, path)
+	return nil
+}
+
+// created returns true if the directory was created by this transaction
+func (tx *transaction) created(dir string) bool {
+	for _, created := range tx.createdDirs {
+		if created == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// isModifiedConffile checks whether a config file on disk differs from the
+// previously installed and from the new version
+func isModifiedConffile(path string, original, update *packet.ManifestEntry) (bool, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() {
+		return true, nil
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		return false, err
+	}
+	if update != nil && hash == update.SHA256 {
+		return false, nil
+	}
+	if original != nil && hash == original.SHA256 {
+		return false, nil
+	}
+	return true, nil
+}
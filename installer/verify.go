@@ -0,0 +1,132 @@
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/trusch/jamesd/packet"
+)
+
+// Problem describes how an installed path differs from its manifest entry
+type Problem string
+
+const (
+	// Missing means the path does not exist anymore
+	Missing Problem = "missing"
+	// Modified means the type, content or link target of the path changed
+	Modified Problem = "modified"
+	// ModeChanged means the permission bits of the path changed
+	ModeChanged Problem = "mode-changed"
+)
+
+// Drift is a single difference between an installed packet and the filesystem
+type Drift struct {
+	Path    string
+	Problem Problem
+}
+
+// Verify compares the files of an installed packet against its manifest.
+// The mode of a directory is only checked if the directory was created by installing the packet.
+func Verify(pack *packet.Packet, installRoot string) ([]*Drift, error) {
+	manifest, err := pack.GetManifest()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := pack.Hash()
+	if err != nil {
+		return nil, err
+	}
+	db, err := OpenDatabase(installRoot)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := db.Packets[hash]
+	if !ok {
+		record = &Record{}
+	}
+	res := make([]*Drift, 0)
+	for _, entry := range manifest {
+		problem, err := verifyEntry(entry, installRoot)
+		if err != nil {
+			return nil, err
+		}
+		if problem == Modified && pack.IsConffile(entry.Path) {
+			// config files are expected to be modified locally
+			continue
+		}
+		if problem == ModeChanged && entry.Type == packet.TypeDir && !record.created(entry.Path) {
+			// existing directories keep their metadata on install
+			continue
+		}
+		if problem != "" {
+			res = append(res, &Drift{Path: entry.Path, Problem: problem})
+		}
+	}
+	return res, nil
+}
+
+func verifyEntry(entry *packet.ManifestEntry, installRoot string) (Problem, error) {
+	path, err := securePath(installRoot, entry.Path, false)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return Missing, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeType != entry.Mode&os.ModeType {
+		return Modified, nil
+	}
+	switch entry.Type {
+	case packet.TypeFile:
+		hash, err := hashFile(path)
+		if err != nil {
+			return "", err
+		}
+		if hash != entry.SHA256 {
+			return Modified, nil
+		}
+	case packet.TypeSymlink:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if link != entry.Link {
+			return Modified, nil
+		}
+		return "", nil
+	case packet.TypeHardlink:
+		link, err := securePath(installRoot, entry.Link, false)
+		if err != nil {
+			return "", err
+		}
+		target, err := os.Lstat(link)
+		if err != nil || !os.SameFile(info, target) {
+			return Modified, nil
+		}
+	}
+	if info.Mode()&modeBits != entry.Mode&modeBits {
+		return ModeChanged, nil
+	}
+	return "", nil
+}
+
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
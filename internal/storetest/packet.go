@@ -0,0 +1,41 @@
+package storetest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/packet"
+)
+
+// NewPacket builds a packet from a control info, including its scripts, and its data files.
+// Files may be located in subdirectories. The hash of the packet is computed.
+func NewPacket(t *testing.T, ctrl packet.ControlInfo, files map[string]string) *packet.Packet {
+	dir, err := ioutil.TempDir("", "jamesd-packet")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, packet.InitDirectory(dir, ctrl.Name, ctrl.Labels))
+	for name, script := range map[string]string{
+		"preinst":     ctrl.PreInst,
+		"postinst":    ctrl.PostInst,
+		"prerm":       ctrl.PreRm,
+		"postrm":      ctrl.PostRm,
+		"preupgrade":  ctrl.PreUpgrade,
+		"postupgrade": ctrl.PostUpgrade,
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755))
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "control"), ctrl.ToYaml(), 0644))
+	for name, content := range files {
+		path := filepath.Join(dir, "data", name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	pack, err := packet.NewFromDirectory(dir)
+	assert.NoError(t, err)
+	_, err = pack.Hash()
+	assert.NoError(t, err)
+	return pack
+}
@@ -0,0 +1,320 @@
+// Package storetest provides an in-memory jamesd store and a packet builder for tests
+package storetest
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/trusch/jamesd/db"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+)
+
+// Memory is an in-memory store with the semantics of *db.DB:
+// controlinfo is kept by name and labels, packet data by hash and specs by id.
+// It also serves as upstream jamesd for mirror tests.
+type Memory struct {
+	mutex  sync.Mutex
+	infos  []*packet.ControlInfo
+	data   map[string][]byte
+	specs  []*spec.Spec
+	merges int
+	reads  int
+}
+
+// New returns an empty store
+func New() *Memory {
+	return &Memory{data: make(map[string][]byte)}
+}
+
+// AddInfos adds controlinfo without packet data, for tests which only look at the metadata
+func (m *Memory) AddInfos(infos ...*packet.ControlInfo) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, info := range infos {
+		m.saveInfo(info)
+		m.data[info.Hash] = nil
+	}
+}
+
+// Infos returns all controlinfo in the order it was added
+func (m *Memory) Infos() []*packet.ControlInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return cloneInfos(m.infos)
+}
+
+// HasData returns true if the data of a packet is stored
+func (m *Memory) HasData(hash string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, ok := m.data[hash]
+	return ok
+}
+
+// DataCount returns the number of stored packets
+func (m *Memory) DataCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.data)
+}
+
+// Merges returns how often GetMergedSpec was called
+func (m *Memory) Merges() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.merges
+}
+
+// Reads returns how often packet data was loaded
+func (m *Memory) Reads() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.reads
+}
+
+// GetPacketNames returns the distinct names of all packets
+func (m *Memory) GetPacketNames() ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, info := range m.infos {
+		if !seen[info.Name] {
+			names = append(names, info.Name)
+			seen[info.Name] = true
+		}
+	}
+	return names, nil
+}
+
+// GetInfos returns the controlinfo of all packets with a name
+func (m *Memory) GetInfos(name string) ([]*packet.ControlInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	res := make([]*packet.ControlInfo, 0)
+	for _, info := range m.infos {
+		if info.Name == name {
+			clone := *info
+			res = append(res, &clone)
+		}
+	}
+	return res, nil
+}
+
+// GetPackets returns the controlinfo of all packets by name
+func (m *Memory) GetPackets() (map[string][]*packet.ControlInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	res := make(map[string][]*packet.ControlInfo)
+	for _, info := range cloneInfos(m.infos) {
+		res[info.Name] = append(res[info.Name], info)
+	}
+	return res, nil
+}
+
+// GetInfo returns the controlinfo of a packet or db.ErrNoPacketFound
+func (m *Memory) GetInfo(hash string) (*packet.ControlInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, info := range m.infos {
+		if info.Hash == hash {
+			clone := *info
+			return &clone, nil
+		}
+	}
+	return nil, db.ErrNoPacketFound
+}
+
+// GetBestInfo returns the packet with the most labels which are all contained in labels
+func (m *Memory) GetBestInfo(name string, labels map[string]string) (*packet.ControlInfo, error) {
+	infos, _ := m.GetInfos(name)
+	var best *packet.ControlInfo
+	for _, info := range infos {
+		if contains(labels, info.Labels) && (best == nil || len(info.Labels) > len(best.Labels)) {
+			best = info
+		}
+	}
+	if best == nil {
+		return nil, db.ErrNoPacketFound
+	}
+	return best, nil
+}
+
+// GetPacket loads a packet from its stored data
+func (m *Memory) GetPacket(hash string) (*packet.Packet, error) {
+	m.mutex.Lock()
+	m.reads++
+	data := m.data[hash]
+	m.mutex.Unlock()
+	if data == nil {
+		return nil, errors.New("not found")
+	}
+	pack, err := packet.NewFromData(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pack.Hash(); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// GetPacketData is GetPacket for mirror tests
+func (m *Memory) GetPacketData(hash string) (*packet.Packet, error) {
+	return m.GetPacket(hash)
+}
+
+// SavePacket stores a packet, it replaces the controlinfo of a packet with the same name and labels
+func (m *Memory) SavePacket(pack *packet.Packet) error {
+	hash, err := pack.Hash()
+	if err != nil {
+		return err
+	}
+	data, err := pack.ToData()
+	if err != nil {
+		return err
+	}
+	// ToData drops the hash from the controlinfo
+	info := pack.ControlInfo
+	info.Hash = hash
+	pack.ControlInfo.Hash = hash
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.saveInfo(&info)
+	m.data[hash] = data
+	return nil
+}
+
+func (m *Memory) saveInfo(info *packet.ControlInfo) {
+	for idx, existing := range m.infos {
+		if existing.Name == info.Name && labelKey(existing.Labels) == labelKey(info.Labels) {
+			m.infos[idx] = info
+			return
+		}
+	}
+	m.infos = append(m.infos, info)
+}
+
+// DeletePacket removes the data and controlinfo of a packet.
+// Like with *db.DB the controlinfo may be gone already if the packet got replaced.
+func (m *Memory) DeletePacket(hash string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.data[hash]; !ok {
+		return errors.New("not found")
+	}
+	delete(m.data, hash)
+	for idx, info := range m.infos {
+		if info.Hash == hash {
+			m.infos = append(m.infos[:idx], m.infos[idx+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// GetSpecs returns all specs, changes to them are visible to the store
+func (m *Memory) GetSpecs() ([]*spec.Spec, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]*spec.Spec{}, m.specs...), nil
+}
+
+// GetSpec returns a spec by id, changes to it are visible to the store
+func (m *Memory) GetSpec(id string) (*spec.Spec, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, s := range m.specs {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+// GetMergedSpec merges all specs whose target is contained in labels
+func (m *Memory) GetMergedSpec(labels map[string]string) (*spec.Spec, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.merges++
+	res := &spec.Spec{Target: make(map[string]string)}
+	for _, s := range m.specs {
+		if !contains(labels, s.Target) {
+			continue
+		}
+		for k, v := range s.Target {
+			res.Target[k] = v
+		}
+		for _, app := range s.Apps {
+			res.Apps = append(res.Apps, app.Clone())
+		}
+	}
+	return res, nil
+}
+
+// SaveSpec stores a copy of a spec, replacing the one with the same id
+func (m *Memory) SaveSpec(s *spec.Spec) error {
+	bs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	clone := &spec.Spec{}
+	if err = json.Unmarshal(bs, clone); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for idx, existing := range m.specs {
+		if existing.ID == s.ID {
+			m.specs[idx] = clone
+			return nil
+		}
+	}
+	m.specs = append(m.specs, clone)
+	return nil
+}
+
+// DeleteSpec removes a spec
+func (m *Memory) DeleteSpec(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for idx, s := range m.specs {
+		if s.ID == id {
+			m.specs = append(m.specs[:idx], m.specs[idx+1:]...)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+func cloneInfos(infos []*packet.ControlInfo) []*packet.ControlInfo {
+	res := make([]*packet.ControlInfo, 0, len(infos))
+	for _, info := range infos {
+		clone := *info
+		res = append(res, &clone)
+	}
+	return res
+}
+
+// contains returns true if all labels of sub are set to the same value in labels
+func contains(labels, sub map[string]string) bool {
+	for k, v := range sub {
+		if val, ok := labels[k]; !ok || val != v {
+			return false
+		}
+	}
+	return true
+}
+
+func labelKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,298 @@
+package mirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+)
+
+// Upstream is the jamesd instance which gets mirrored, it is implemented by *cli.Client
+type Upstream interface {
+	GetPackets() (map[string][]*packet.ControlInfo, error)
+	GetPacketData(hash string) (*packet.Packet, error)
+	GetSpecs() ([]*spec.Spec, error)
+}
+
+// Store is the database of the mirror, it is implemented by *db.DB
+type Store interface {
+	GetPacketNames() ([]string, error)
+	GetInfos(name string) ([]*packet.ControlInfo, error)
+	SavePacket(pack *packet.Packet) error
+	DeletePacket(hash string) error
+	GetSpecs() ([]*spec.Spec, error)
+	SaveSpec(s *spec.Spec) error
+	DeleteSpec(id string) error
+}
+
+// Conflict describes a packet or spec which was changed locally and upstream
+type Conflict struct {
+	Kind   string
+	ID     string
+	Reason string
+}
+
+func (c *Conflict) String() string {
+	return fmt.Sprintf("%v %v: %v", c.Kind, c.ID, c.Reason)
+}
+
+// Report summarizes a sync
+type Report struct {
+	Packets   int
+	Specs     int
+	Deleted   int
+	Conflicts []*Conflict
+}
+
+// Mirror replicates packets and specs from an upstream jamesd into a local store.
+// Packets whose labels contradict Labels and specs whose target contradicts Labels are skipped.
+// Everything mirrored is remembered in the state file, so local modifications can be told apart from
+// upstream updates: locally modified entries are reported as conflicts and never overwritten.
+type Mirror struct {
+	Upstream Upstream
+	Store    Store
+	Labels   map[string]string
+	// Delete enables the removal of mirrored entries which were deleted upstream
+	Delete    bool
+	StateFile string
+}
+
+// state remembers what was mirrored: packet keys map to hashes and spec ids to content hashes
+type state struct {
+	Packets map[string]string
+	Specs   map[string]string
+}
+
+// Sync does a single replication run
+func (m *Mirror) Sync() (*Report, error) {
+	st, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	if err = m.syncPackets(st, report); err != nil {
+		return report, err
+	}
+	if err = m.syncSpecs(st, report); err != nil {
+		return report, err
+	}
+	return report, m.saveState(st)
+}
+
+func (m *Mirror) syncPackets(st *state, report *Report) error {
+	upstream, err := m.Upstream.GetPackets()
+	if err != nil {
+		return err
+	}
+	local, err := m.localPackets()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, infos := range upstream {
+		for _, info := range infos {
+			if !matches(info.Labels, m.Labels) {
+				continue
+			}
+			key := packetKey(info)
+			seen[key] = true
+			localHash, exists := local[key]
+			if exists && localHash == info.Hash {
+				st.Packets[key] = info.Hash
+				continue
+			}
+			if exists && localHash != st.Packets[key] {
+				report.Conflicts = append(report.Conflicts, &Conflict{
+					Kind:   "packet",
+					ID:     key,
+					Reason: fmt.Sprintf("local packet %v differs from upstream packet %v", localHash, info.Hash),
+				})
+				continue
+			}
+			pack, err := m.Upstream.GetPacketData(info.Hash)
+			if err != nil {
+				return err
+			}
+			hash, err := pack.Hash()
+			if err != nil {
+				return err
+			}
+			if hash != info.Hash {
+				return fmt.Errorf("packet hash mismatch: expected %v, got %v", info.Hash, hash)
+			}
+			if err = m.Store.SavePacket(pack); err != nil {
+				return err
+			}
+			if exists {
+				// the packet got replaced upstream, drop the data of the old one
+				if err = m.Store.DeletePacket(localHash); err != nil {
+					return err
+				}
+			}
+			st.Packets[key] = info.Hash
+			report.Packets++
+		}
+	}
+	for key, hash := range st.Packets {
+		if seen[key] {
+			continue
+		}
+		if localHash, exists := local[key]; m.Delete && exists && localHash == hash {
+			if err = m.Store.DeletePacket(hash); err != nil {
+				return err
+			}
+			report.Deleted++
+		}
+		delete(st.Packets, key)
+	}
+	return nil
+}
+
+// localPackets returns the hashes of all local packets by their key
+func (m *Mirror) localPackets() (map[string]string, error) {
+	names, err := m.Store.GetPacketNames()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string)
+	for _, name := range names {
+		infos, err := m.Store.GetInfos(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			res[packetKey(info)] = info.Hash
+		}
+	}
+	return res, nil
+}
+
+func (m *Mirror) syncSpecs(st *state, report *Report) error {
+	upstream, err := m.Upstream.GetSpecs()
+	if err != nil {
+		return err
+	}
+	localSpecs, err := m.Store.GetSpecs()
+	if err != nil {
+		return err
+	}
+	local := make(map[string]string)
+	for _, s := range localSpecs {
+		local[s.ID] = specHash(s)
+	}
+	seen := make(map[string]bool)
+	for _, s := range upstream {
+		if !matches(s.Target, m.Labels) {
+			continue
+		}
+		seen[s.ID] = true
+		hash := specHash(s)
+		localHash, exists := local[s.ID]
+		if exists && localHash == hash {
+			st.Specs[s.ID] = hash
+			continue
+		}
+		if exists && localHash != st.Specs[s.ID] {
+			report.Conflicts = append(report.Conflicts, &Conflict{
+				Kind:   "spec",
+				ID:     s.ID,
+				Reason: "changed locally and upstream",
+			})
+			continue
+		}
+		if err = m.Store.SaveSpec(s); err != nil {
+			return err
+		}
+		st.Specs[s.ID] = hash
+		report.Specs++
+	}
+	for id, hash := range st.Specs {
+		if seen[id] {
+			continue
+		}
+		if localHash, exists := local[id]; m.Delete && exists && localHash == hash {
+			if err = m.Store.DeleteSpec(id); err != nil {
+				return err
+			}
+			report.Deleted++
+		}
+		delete(st.Specs, id)
+	}
+	return nil
+}
+
+func (m *Mirror) loadState() (*state, error) {
+	st := &state{Packets: make(map[string]string), Specs: make(map[string]string)}
+	if m.StateFile == "" {
+		return st, nil
+	}
+	bs, err := ioutil.ReadFile(m.StateFile)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(bs, st); err != nil {
+		return nil, err
+	}
+	if st.Packets == nil {
+		st.Packets = make(map[string]string)
+	}
+	if st.Specs == nil {
+		st.Specs = make(map[string]string)
+	}
+	return st, nil
+}
+
+func (m *Mirror) saveState(st *state) error {
+	if m.StateFile == "" {
+		return nil
+	}
+	bs, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(m.StateFile), 0755); err != nil {
+		return err
+	}
+	tmp := m.StateFile + ".tmp"
+	if err = ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.StateFile)
+}
+
+// matches returns false if labels contain a key of filter with a different value
+func matches(labels, filter map[string]string) bool {
+	for key, value := range filter {
+		if v, ok := labels[key]; ok && v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// packetKey identifies a packet by its name and labels, like the database does
+func packetKey(info *packet.ControlInfo) string {
+	pairs := make([]string, 0, len(info.Labels))
+	for key, value := range info.Labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return info.Name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func specHash(s *spec.Spec) string {
+	bs, _ := json.Marshal(s)
+	hash := sha256.Sum256(bs)
+	return hex.EncodeToString(hash[:])
+}
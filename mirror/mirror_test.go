@@ -0,0 +1,89 @@
+package mirror
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/internal/storetest"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+)
+
+func TestMirror(t *testing.T) {
+	defer os.RemoveAll("./test-mirror")
+	upstream, local := storetest.New(), storetest.New()
+	m := &Mirror{
+		Upstream:  upstream,
+		Store:     local,
+		Labels:    map[string]string{"region": "eu"},
+		Delete:    true,
+		StateFile: "./test-mirror/state.json",
+	}
+	assert.NoError(t, upstream.SavePacket(storetest.NewPacket(t, packet.ControlInfo{Name: "app", Labels: map[string]string{"version": "1"}}, nil)))
+	assert.NoError(t, upstream.SavePacket(storetest.NewPacket(t, packet.ControlInfo{Name: "app", Labels: map[string]string{"region": "us"}}, nil)))
+	assert.NoError(t, upstream.SaveSpec(&spec.Spec{ID: "eu", Target: map[string]string{"region": "eu"}}))
+	assert.NoError(t, upstream.SaveSpec(&spec.Spec{ID: "us", Target: map[string]string{"region": "us"}}))
+
+	report, err := m.Sync()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Packets)
+	assert.Equal(t, 1, report.Specs)
+	assert.Empty(t, report.Conflicts)
+	assert.Equal(t, 1, local.DataCount())
+	_, err = local.GetSpec("eu")
+	assert.NoError(t, err)
+
+	// upstream updates are replicated
+	upstreamSpec, err := upstream.GetSpec("eu")
+	assert.NoError(t, err)
+	upstreamSpec.Target["fleet"] = "alpha"
+	report, err = m.Sync()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Specs)
+	localSpec, err := local.GetSpec("eu")
+	assert.NoError(t, err)
+	assert.Equal(t, "alpha", localSpec.Target["fleet"])
+
+	// local modifications are reported and kept
+	assert.NoError(t, local.SaveSpec(&spec.Spec{ID: "eu", Target: map[string]string{"local": "true"}}))
+	upstreamSpec.Target["fleet"] = "beta"
+	report, err = m.Sync()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(report.Conflicts))
+	localSpec, err = local.GetSpec("eu")
+	assert.NoError(t, err)
+	assert.Equal(t, "true", localSpec.Target["local"])
+
+	// deletions are replicated
+	for _, info := range upstream.Infos() {
+		assert.NoError(t, upstream.DeletePacket(info.Hash))
+	}
+	report, err = m.Sync()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Deleted)
+	assert.Empty(t, local.Infos())
+	assert.Equal(t, 0, local.DataCount())
+}
+
+func TestMirrorReplacedPacket(t *testing.T) {
+	defer os.RemoveAll("./test-mirror")
+	upstream, local := storetest.New(), storetest.New()
+	m := &Mirror{Upstream: upstream, Store: local, StateFile: "./test-mirror/state.json"}
+	v1 := storetest.NewPacket(t, packet.ControlInfo{Name: "app", Labels: map[string]string{"arch": "amd64"}, Scripts: packet.Scripts{PostInst: "echo v1"}}, nil)
+	assert.NoError(t, upstream.SavePacket(v1))
+	_, err := m.Sync()
+	assert.NoError(t, err)
+
+	// the new packet has the same name and labels, so saving it replaces the controlinfo of the old one
+	v2 := storetest.NewPacket(t, packet.ControlInfo{Name: "app", Labels: map[string]string{"arch": "amd64"}, Scripts: packet.Scripts{PostInst: "echo v2"}}, nil)
+	assert.NoError(t, upstream.SavePacket(v2))
+	report, err := m.Sync()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Packets)
+	assert.Empty(t, report.Conflicts)
+	assert.Len(t, local.Infos(), 1)
+	assert.Equal(t, 1, local.DataCount())
+	assert.True(t, local.HasData(v2.ControlInfo.Hash))
+	assert.False(t, local.HasData(v1.ControlInfo.Hash))
+}
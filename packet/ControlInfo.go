@@ -11,18 +11,44 @@ import (
 
 // ControlInfo contains the metadata of a packet
 type ControlInfo struct {
-	Name    string
-	Labels  map[string]string
-	Hash    string `yaml:"hash,omitempty"`
-	Scripts `yaml:"-"`
+	Name      string
+	Labels    map[string]string
+	Hash      string   `yaml:"hash,omitempty"`
+	Conffiles []string `yaml:"conffiles,omitempty"`
+	Replaces  []string `yaml:"replaces,omitempty"`
+	Scripts   `yaml:"-"`
+	Manifest  Manifest `yaml:"-" json:"-" bson:"-"`
 }
 
 // Scripts is a wrapper for install/deinstall related scripts
 type Scripts struct {
-	PreInst  string
-	PostInst string
-	PreRm    string
-	PostRm   string
+	PreInst     string
+	PostInst    string
+	PreRm       string
+	PostRm      string
+	PreUpgrade  string
+	PostUpgrade string
+}
+
+// IsConffile returns whether a path is declared as config file of the packet
+func (info *ControlInfo) IsConffile(path string) bool {
+	path = cleanPath(path)
+	for _, conffile := range info.Conffiles {
+		if cleanPath(conffile) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplacesPacket returns whether the packet may take over paths of the packet with the given name
+func (info *ControlInfo) ReplacesPacket(name string) bool {
+	for _, replaced := range info.Replaces {
+		if replaced == name {
+			return true
+		}
+	}
+	return false
 }
 
 // ToYaml dumps the controlinfo as yaml
@@ -62,6 +88,25 @@ func (info *ControlInfo) ToData() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	// the upgrade scripts and the manifest are optional, so packets without them keep their hash
+	if info.Scripts.PreUpgrade != "" {
+		err = addDataToTarWriter(controlTarWriter, []byte(info.Scripts.PreUpgrade), "preupgrade")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if info.Scripts.PostUpgrade != "" {
+		err = addDataToTarWriter(controlTarWriter, []byte(info.Scripts.PostUpgrade), "postupgrade")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(info.Manifest) > 0 {
+		err = addDataToTarWriter(controlTarWriter, info.Manifest.ToYaml(), "manifest")
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	err = controlTarWriter.Close()
 	if err != nil {
@@ -132,6 +177,36 @@ func (info *ControlInfo) FromData(data []byte) error {
 				}
 				info.Scripts.PostRm = string(buf.Bytes())
 			}
+		case "preupgrade":
+			{
+				buf := &bytes.Buffer{}
+				_, err := io.Copy(buf, reader)
+				if err != nil {
+					return err
+				}
+				info.Scripts.PreUpgrade = string(buf.Bytes())
+			}
+		case "postupgrade":
+			{
+				buf := &bytes.Buffer{}
+				_, err := io.Copy(buf, reader)
+				if err != nil {
+					return err
+				}
+				info.Scripts.PostUpgrade = string(buf.Bytes())
+			}
+		case "manifest":
+			{
+				buf := &bytes.Buffer{}
+				_, err := io.Copy(buf, reader)
+				if err != nil {
+					return err
+				}
+				err = info.Manifest.FromYaml(buf.Bytes())
+				if err != nil {
+					return err
+				}
+			}
 		}
 		return nil
 	})
@@ -0,0 +1,146 @@
+package packet
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// FileType describes the kind of a filesystem entry in a manifest
+type FileType string
+
+const (
+	// TypeFile is a regular file
+	TypeFile FileType = "file"
+	// TypeDir is a directory
+	TypeDir FileType = "dir"
+	// TypeSymlink is a symbolic link
+	TypeSymlink FileType = "symlink"
+	// TypeHardlink is a hardlink to another file of the packet
+	TypeHardlink FileType = "hardlink"
+	// TypeFifo is a named pipe
+	TypeFifo FileType = "fifo"
+	// TypeDevice is a character or block device node
+	TypeDevice FileType = "device"
+)
+
+// ManifestEntry describes a single path contained in the data archive of a packet
+type ManifestEntry struct {
+	Path   string
+	Type   FileType
+	Mode   os.FileMode
+	UID    int
+	GID    int
+	Size   int64
+	SHA256 string `yaml:"sha256,omitempty"`
+	Link   string `yaml:"link,omitempty"`
+}
+
+// A Manifest is the list of all paths contained in the data archive of a packet
+type Manifest []*ManifestEntry
+
+// ToYaml dumps the manifest as yaml
+func (manifest Manifest) ToYaml() []byte {
+	d, _ := yaml.Marshal(manifest)
+	return d
+}
+
+// FromYaml parses the manifest from yaml data
+func (manifest *Manifest) FromYaml(data []byte) error {
+	return yaml.Unmarshal(data, manifest)
+}
+
+// Get returns the entry for a given path or nil if the path is not in the manifest
+func (manifest Manifest) Get(path string) *ManifestEntry {
+	path = cleanPath(path)
+	for _, entry := range manifest {
+		if entry.Path == path {
+			return entry
+		}
+	}
+	return nil
+}
+
+// NewManifestFromTar computes the manifest of a tar archive
+func NewManifestFromTar(archive *tar.Reader) (Manifest, error) {
+	manifest := Manifest{}
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		path := cleanPath(hdr.Name)
+		if path == "" {
+			continue
+		}
+		entry := &ManifestEntry{
+			Path: path,
+			Mode: hdr.FileInfo().Mode(),
+			UID:  hdr.Uid,
+			GID:  hdr.Gid,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			entry.Type = TypeDir
+		case tar.TypeSymlink:
+			entry.Type = TypeSymlink
+			entry.Link = hdr.Linkname
+		case tar.TypeLink:
+			entry.Type = TypeHardlink
+			entry.Link = cleanPath(hdr.Linkname)
+		case tar.TypeFifo:
+			entry.Type = TypeFifo
+		case tar.TypeChar, tar.TypeBlock:
+			entry.Type = TypeDevice
+		case tar.TypeReg, tar.TypeRegA:
+			entry.Type = TypeFile
+			hash := sha256.New()
+			n, err := io.Copy(hash, archive)
+			if err != nil {
+				return nil, err
+			}
+			entry.Size = n
+			entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		default:
+			continue
+		}
+		manifest = append(manifest, entry)
+	}
+	return manifest, nil
+}
+
+// Verify checks that the content of a tar archive matches the manifest
+func (manifest Manifest) Verify(archive *tar.Reader) error {
+	actual, err := NewManifestFromTar(archive)
+	if err != nil {
+		return err
+	}
+	if len(actual) != len(manifest) {
+		return errors.New("manifest mismatch: archive contains a different number of entries")
+	}
+	for _, entry := range actual {
+		expected := manifest.Get(entry.Path)
+		if expected == nil {
+			return fmt.Errorf("manifest mismatch: %v is not listed", entry.Path)
+		}
+		if *expected != *entry {
+			return fmt.Errorf("manifest mismatch: %v differs", entry.Path)
+		}
+	}
+	return nil
+}
+
+// cleanPath normalizes archive paths, so "./foo/" and "foo" are treated the same
+func cleanPath(path string) string {
+	return filepath.Clean(filepath.Join("/", path))[1:]
+}
@@ -94,17 +94,38 @@ func (packet *Packet) Hash() (string, error) {
 	if packet.ControlInfo.Hash != "" {
 		return packet.ControlInfo.Hash, nil
 	}
-	hash := make([]byte, 16)
 	data, err := packet.ToData()
 	if err != nil {
 		return "", err
 	}
-	sha3.ShakeSum256(hash, data)
-	str := hex.EncodeToString(hash)
+	str, err := HashData(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
 	packet.ControlInfo.Hash = str
 	return str, nil
 }
 
+// HashData computes the hash of serialized packet data as returned by ToData
+func HashData(r io.Reader) (string, error) {
+	shake := sha3.NewShake256()
+	if _, err := io.Copy(shake, r); err != nil {
+		return "", err
+	}
+	hash := make([]byte, 16)
+	shake.Read(hash)
+	return hex.EncodeToString(hash), nil
+}
+
+// GetManifest returns the manifest of the packet.
+// Packets built without a manifest get one computed from their data archive.
+func (packet *Packet) GetManifest() (Manifest, error) {
+	if len(packet.ControlInfo.Manifest) > 0 {
+		return packet.ControlInfo.Manifest, nil
+	}
+	return NewManifestFromTar(packet.Data.GetReader())
+}
+
 // NewFromData returns a new packet parsed from data
 func NewFromData(data []byte) (*Packet, error) {
 	pack := &Packet{}
@@ -131,7 +152,7 @@ func NewFromFile(file string) (*Packet, error) {
 
 // NewFromDirectory parses a directory producing a packet
 func NewFromDirectory(dir string) (*Packet, error) {
-	data, err := tatar.NewFromDirectory(filepath.Join(dir, "data"))
+	data, err := newTarFromDirectory(filepath.Join(dir, "data"))
 	if err != nil {
 		return nil, err
 	}
@@ -157,6 +178,16 @@ func NewFromDirectory(dir string) (*Packet, error) {
 		return nil, err
 	}
 
+	preUpgrade, err := readOptionalFile(filepath.Join(dir, "preupgrade"))
+	if err != nil {
+		return nil, err
+	}
+
+	postUpgrade, err := readOptionalFile(filepath.Join(dir, "postupgrade"))
+	if err != nil {
+		return nil, err
+	}
+
 	control, err := ioutil.ReadFile(filepath.Join(dir, "control"))
 	if err != nil {
 		return nil, err
@@ -168,16 +199,26 @@ func NewFromDirectory(dir string) (*Packet, error) {
 		return nil, err
 	}
 
+	manifest, err := NewManifestFromTar(data.GetReader())
+	if err != nil {
+		return nil, err
+	}
+
 	pack := &Packet{
 		ControlInfo: ControlInfo{
-			Name:   info.Name,
-			Labels: info.Labels,
+			Name:      info.Name,
+			Labels:    info.Labels,
+			Conffiles: info.Conffiles,
+			Replaces:  info.Replaces,
 			Scripts: Scripts{
-				PreInst:  string(preInst),
-				PostInst: string(postInst),
-				PreRm:    string(preRm),
-				PostRm:   string(postRm),
+				PreInst:     string(preInst),
+				PostInst:    string(postInst),
+				PreRm:       string(preRm),
+				PostRm:      string(postRm),
+				PreUpgrade:  string(preUpgrade),
+				PostUpgrade: string(postUpgrade),
 			},
+			Manifest: manifest,
 		},
 		Data: data,
 	}
@@ -195,6 +236,8 @@ func InitDirectory(dir, name string, labels map[string]string) error {
 	ioutil.WriteFile(filepath.Join(dir, "postinst"), []byte{}, 0755)
 	ioutil.WriteFile(filepath.Join(dir, "prerm"), []byte{}, 0755)
 	ioutil.WriteFile(filepath.Join(dir, "postrm"), []byte{}, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "preupgrade"), []byte{}, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "postupgrade"), []byte{}, 0755)
 	ctrl := ControlInfo{
 		Name:   name,
 		Labels: labels,
@@ -222,6 +265,15 @@ func checkDir(dir string) error {
 	return errors.New("target directory already exists and is not empty")
 }
 
+// readOptionalFile reads a file which may be missing in packet directories created by older versions
+func readOptionalFile(file string) ([]byte, error) {
+	bs, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return bs, err
+}
+
 func addDataToTarWriter(t *tar.Writer, data []byte, name string) error {
 	err := t.WriteHeader(&tar.Header{
 		Name: name,
@@ -0,0 +1,82 @@
+package packet
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ValidatePath checks that a path of the data archive stays inside of the install root
+func ValidatePath(name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid path: empty name")
+	}
+	if path.IsAbs(name) {
+		return fmt.Errorf("invalid path %v: absolute paths are not allowed", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return fmt.Errorf("invalid path %v: '..' is not allowed", name)
+		}
+	}
+	return nil
+}
+
+// ValidateHash checks that a string is a well formed packet hash, so it can be used as a filename
+func ValidateHash(hash string) error {
+	if len(hash) != 32 {
+		return fmt.Errorf("invalid hash %q", hash)
+	}
+	for _, r := range hash {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return fmt.Errorf("invalid hash %q", hash)
+		}
+	}
+	return nil
+}
+
+// Validate checks that no entry of the data archive can be written outside of the install root.
+// This rejects absolute paths, '..' components, hardlinks to paths not in the archive,
+// relative symlinks escaping the root and entries placed below a symlink of the archive.
+func (packet *Packet) Validate() error {
+	archive := packet.Data.GetReader()
+	entries := make(map[string]byte)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err = ValidatePath(hdr.Name); err != nil {
+			return err
+		}
+		name := cleanPath(hdr.Name)
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if entries[dir] == tar.TypeSymlink {
+				return fmt.Errorf("invalid path %v: parent directory %v is a symlink", hdr.Name, dir)
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeLink:
+			if err = ValidatePath(hdr.Linkname); err != nil {
+				return err
+			}
+			if _, ok := entries[cleanPath(hdr.Linkname)]; !ok {
+				return fmt.Errorf("invalid hardlink %v: target %v is not part of the packet", hdr.Name, hdr.Linkname)
+			}
+		case tar.TypeSymlink:
+			if !path.IsAbs(hdr.Linkname) {
+				target := path.Join(path.Dir(name), hdr.Linkname)
+				if target == ".." || strings.HasPrefix(target, "../") {
+					return fmt.Errorf("invalid symlink %v: target %v points outside of the install root", hdr.Name, hdr.Linkname)
+				}
+			}
+		}
+		entries[name] = hdr.Typeflag
+	}
+	return nil
+}
@@ -85,3 +85,24 @@ func TestHash(t *testing.T) {
 	assert.Equal(t, hash, hash3)
 	fmt.Println(hash)
 }
+
+func TestManifest(t *testing.T) {
+	InitDirectory("./test", "test-packet", map[string]string{"a": "label"})
+	defer os.RemoveAll("./test")
+	ioutil.WriteFile("./test/data/foo", []byte("bar"), 0755)
+	pack, err := NewFromDirectory("./test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pack.Manifest))
+	entry := pack.Manifest.Get("foo")
+	assert.NotNil(t, entry)
+	assert.Equal(t, TypeFile, entry.Type)
+	assert.Equal(t, int64(3), entry.Size)
+	assert.Equal(t, "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9", entry.SHA256)
+	assert.NoError(t, pack.Manifest.Verify(pack.Data.GetReader()))
+	data, _ := pack.ToData()
+	restored, err := NewFromData(data)
+	assert.NoError(t, err)
+	assert.Equal(t, pack.Manifest, restored.Manifest)
+	entry.SHA256 = "deadbeef"
+	assert.Error(t, pack.Manifest.Verify(pack.Data.GetReader()))
+}
@@ -0,0 +1,50 @@
+// Package resolve decides which packets the apps of a spec resolve to for a device
+package resolve
+
+import (
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+)
+
+// Request returns the labels used to resolve an app for a device.
+// The labels of the device take precedence over the labels of the app.
+func Request(app *spec.App, labels map[string]string) map[string]string {
+	req := make(map[string]string)
+	for k, v := range app.Labels {
+		req[k] = v
+	}
+	for k, v := range labels {
+		req[k] = v
+	}
+	return req
+}
+
+// BestMatches returns the packets jamesd would resolve for a request: the packets whose labels are
+// all contained in it with the highest label count. Several packets are returned on ties.
+func BestMatches(infos []*packet.ControlInfo, req map[string]string) []*packet.ControlInfo {
+	var res []*packet.ControlInfo
+	max := -1
+	for _, info := range infos {
+		if !Contains(req, info.Labels) {
+			continue
+		}
+		switch count := len(info.Labels); {
+		case count > max:
+			res = []*packet.ControlInfo{info}
+			max = count
+		case count == max:
+			res = append(res, info)
+		}
+	}
+	return res
+}
+
+// Contains returns true if all labels of sub are set to the same value in labels
+func Contains(labels, sub map[string]string) bool {
+	for k, v := range sub {
+		if val, ok := labels[k]; !ok || val != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package resolve
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/packet"
+	"github.com/trusch/jamesd/spec"
+)
+
+func info(hash string, labels map[string]string) *packet.ControlInfo {
+	return &packet.ControlInfo{Name: "app", Hash: hash, Labels: labels}
+}
+
+func hashes(infos []*packet.ControlInfo) []string {
+	res := make([]string, 0, len(infos))
+	for _, info := range infos {
+		res = append(res, info.Hash)
+	}
+	return res
+}
+
+func TestRequest(t *testing.T) {
+	app := &spec.App{Name: "app", Labels: map[string]string{"arch": "amd64", "channel": "beta"}}
+	req := Request(app, map[string]string{"arch": "arm", "fleet": "a"})
+	assert.Equal(t, map[string]string{"arch": "arm", "channel": "beta", "fleet": "a"}, req)
+	assert.Equal(t, "amd64", app.Labels["arch"], "the app must not be modified")
+}
+
+func TestBestMatches(t *testing.T) {
+	infos := []*packet.ControlInfo{
+		info("generic", map[string]string{}),
+		info("arm", map[string]string{"arch": "arm"}),
+		info("x86", map[string]string{"arch": "x86"}),
+		info("arm-beta", map[string]string{"arch": "arm", "channel": "beta"}),
+		info("arm-a", map[string]string{"arch": "arm", "fleet": "a"}),
+	}
+	assert.Equal(t, []string{"arm"}, hashes(BestMatches(infos, map[string]string{"arch": "arm", "fleet": "b"})))
+	assert.Equal(t, []string{"generic"}, hashes(BestMatches(infos, map[string]string{"fleet": "a"})))
+	assert.Equal(t, []string{"arm-beta", "arm-a"}, hashes(BestMatches(infos, map[string]string{"arch": "arm", "channel": "beta", "fleet": "a"})))
+	assert.Empty(t, BestMatches(infos[1:], map[string]string{"arch": "mips"}))
+}
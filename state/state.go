@@ -1,14 +1,58 @@
 package state
 
-import "github.com/trusch/jamesd/spec"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/trusch/jamesd/spec"
+)
 
 // State represents the state of a machine, i.e. which packets are installed (or should be installed)
+// Revision identifies the apps of the state, it changes whenever an app is added, removed or replaced.
+// Unresolved lists the apps no packet was found for, jamesc keeps their installed packets.
+// Older jamesc versions only know Apps and uninstall the packets of unresolved apps like those of removed apps.
 type State struct {
-	Apps []*App
+	Apps       []*App
+	Unresolved []*App `json:",omitempty"`
+	Revision   string `json:",omitempty"`
 }
 
-// App represents a single installed packet
+// App represents a single installed packet.
+// In a desired state Status tells whether a packet was found for the app, Reason explains why not.
 type App struct {
 	*spec.App
-	Hash string
+	Hash   string
+	Status Status `json:",omitempty"`
+	Reason string `json:",omitempty"`
+}
+
+// Status is the outcome of resolving an app to a packet
+type Status string
+
+const (
+	// StatusResolved means the best matching packet was found
+	StatusResolved Status = "resolved"
+	// StatusNoMatch means no packet matches the labels of the app
+	StatusNoMatch Status = "no-match"
+	// StatusError means the app could not be resolved because of an error
+	StatusError Status = "error"
+)
+
+// ComputeRevision computes the revision of the state from the hashes of its apps
+// and the status of its unresolved apps
+func (s *State) ComputeRevision() string {
+	lines := make([]string, 0, len(s.Apps)+len(s.Unresolved))
+	for _, app := range s.Apps {
+		lines = append(lines, app.Name+" "+app.Hash+"\n")
+	}
+	for _, app := range s.Unresolved {
+		lines = append(lines, app.Name+" "+string(app.Status)+" "+app.Reason+"\n")
+	}
+	sort.Strings(lines)
+	hash := sha256.New()
+	for _, line := range lines {
+		hash.Write([]byte(line))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:32]
 }
@@ -0,0 +1,24 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trusch/jamesd/spec"
+)
+
+func TestComputeRevision(t *testing.T) {
+	a := &App{App: &spec.App{Name: "a"}, Hash: "a1", Status: StatusResolved}
+	b := &App{App: &spec.App{Name: "b"}, Hash: "b1", Status: StatusResolved}
+	s := &State{Apps: []*App{a, b}}
+	revision := s.ComputeRevision()
+	assert.Len(t, revision, 32)
+	assert.Equal(t, revision, (&State{Apps: []*App{b, a}}).ComputeRevision(), "the order of the apps does not matter")
+
+	// the status of unresolved apps is part of the revision
+	s.Unresolved = []*App{{App: &spec.App{Name: "c"}, Status: StatusNoMatch, Reason: "no packet found"}}
+	noMatch := s.ComputeRevision()
+	assert.NotEqual(t, revision, noMatch)
+	s.Unresolved[0].Status, s.Unresolved[0].Reason = StatusError, "connection refused"
+	assert.NotEqual(t, noMatch, s.ComputeRevision())
+}